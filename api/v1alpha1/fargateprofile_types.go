@@ -17,9 +17,17 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/eks"
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type Phase string
@@ -28,10 +36,150 @@ const (
 	Ready             Phase = "Ready"
 	Creating          Phase = "Creating"
 	Deleting          Phase = "Deleting"
+	Replacing         Phase = "Replacing"
 	Failed            Phase = "Failed"
 	PendingEvaluation Phase = "Pending-Evaluation"
+	// Unmanaged means a fargate-profile with the same name exists and is left alone because of AdoptionPolicy Ignore
+	Unmanaged Phase = "Unmanaged"
+)
+
+// MaxFargateProfileNameLength is the longest fargate-profile name EKS accepts
+const MaxFargateProfileNameLength = 100
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9\-_]`)
+
+type UpdateStrategy string
+
+const (
+	// Recreate deletes the fargate-profile and creates it again once the deletion is complete
+	Recreate UpdateStrategy = "Recreate"
+	// BlueGreen creates a new suffixed fargate-profile and deletes the old one once the new one is ACTIVE
+	BlueGreen UpdateStrategy = "BlueGreen"
+)
+
+type AdoptionPolicy string
+
+const (
+	// Adopt takes over an existing fargate-profile when it matches the spec
+	Adopt AdoptionPolicy = "Adopt"
+	// Fail refuses to touch an existing fargate-profile and marks the CR Failed
+	Fail AdoptionPolicy = "Fail"
+	// Ignore reports the existing fargate-profile in status without ever changing or deleting it
+	Ignore AdoptionPolicy = "Ignore"
+)
+
+type DeletionPolicy string
+
+const (
+	// Delete deletes the fargate-profile on AWS side when the CR is deleted
+	Delete DeletionPolicy = "Delete"
+	// Retain leaves the fargate-profile on AWS side when the CR is deleted
+	Retain DeletionPolicy = "Retain"
+)
+
+type DriftPolicy string
+
+const (
+	// Heal brings a fargate-profile that was changed outside of the controller back to the spec
+	Heal DriftPolicy = "Heal"
+	// Report only surfaces the drift of the fargate-profile in status and events
+	Report DriftPolicy = "Report"
+)
+
+type TagPrecedence string
+
+const (
+	// SpecTagsFirst lets spec.tags override a controller default tag with the same key
+	SpecTagsFirst TagPrecedence = "Spec"
+	// DefaultTagsFirst lets a controller default tag override spec.tags with the same key
+	DefaultTagsFirst TagPrecedence = "Default"
+)
+
+// DefaultTags are the controller-wide tags merged into the tags of every fargate-profile
+// +kubebuilder:object:generate=false
+type DefaultTags struct {
+	Tags       map[string]string
+	Precedence TagPrecedence
+	// ProtectedKeys are tag keys spec.tags may not set to anything but the default value
+	ProtectedKeys []string
+}
+
+// tags the controller puts on every fargate-profile it creates or adopts, they tell which CR owns the profile
+const (
+	ManagedTagPrefix   = "eks-fargate-controller/"
+	ManagedByTagKey    = ManagedTagPrefix + "managed-by"
+	ManagedByTagValue  = "eks-fargate-controller"
+	OwnerUIDTagKey     = ManagedTagPrefix + "owner-uid"
+	OwnerTagKey        = ManagedTagPrefix + "owner"
+	ControllerIDTagKey = ManagedTagPrefix + "controller-id"
+)
+
+type ConditionType string
+
+const (
+	// ClusterReady tells whether the eks cluster exists and is ACTIVE
+	ClusterReady ConditionType = "ClusterReady"
+	// RoleValid tells whether the pod execution role exists
+	RoleValid ConditionType = "RoleValid"
+	// SubnetsValid tells whether all subnets exist in the cluster VPC and are private
+	SubnetsValid ConditionType = "SubnetsValid"
+	// ProfileActive tells whether the fargate-profile is ACTIVE on AWS side
+	ProfileActive ConditionType = "ProfileActive"
+	// Synced tells whether the fargate-profile on AWS side matches the spec
+	Synced ConditionType = "Synced"
+	// Queued tells whether the CR waits for another CR to finish creating or deleting a profile of the same cluster
+	Queued ConditionType = "Queued"
+	// Adopted tells whether a fargate-profile that existed before the CR is managed by it
+	Adopted ConditionType = "Adopted"
+	// TagsSynced tells whether the tags of the fargate-profile on AWS side match spec.tags
+	TagsSynced ConditionType = "TagsSynced"
+	// Drifted tells whether the fargate-profile on AWS side was changed outside of the controller
+	Drifted ConditionType = "Drifted"
 )
 
+// Condition mirrors metav1.Condition, which is not available in the apimachinery version used here
+type Condition struct {
+	// Type of condition in CamelCase.
+	Type ConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown.
+	// +kubebuilder:validation:Enum=True;False;Unknown
+	Status metav1.ConditionStatus `json:"status"`
+
+	// The .metadata.generation that the condition was set based upon.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+
+	// A programmatic identifier indicating the reason for the condition's last transition.
+	Reason string `json:"reason"`
+
+	// A human readable message indicating details about the transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// CredentialsSecretRef points to a Secret holding the AWS credentials to use for a FargateProfile.
+// The Secret must live in the namespace of the FargateProfile, so nobody can use the credentials of another namespace.
+type CredentialsSecretRef struct {
+	// The name of the Secret.
+	Name string `json:"name"`
+
+	// The key holding the access key id, defaults to AWS_ACCESS_KEY_ID.
+	// +optional
+	AccessKeyIDKey string `json:"accessKeyIdKey,omitempty"`
+
+	// The key holding the secret access key, defaults to AWS_SECRET_ACCESS_KEY.
+	// +optional
+	SecretAccessKeyKey string `json:"secretAccessKeyKey,omitempty"`
+
+	// The key holding the session token, defaults to AWS_SESSION_TOKEN. The token is optional in the Secret.
+	// +optional
+	SessionTokenKey string `json:"sessionTokenKey,omitempty"`
+}
+
 type FargateProfileSelector struct {
 	// The Kubernetes labels that the selector should match. A pod must contain
 	// all of the labels that are specified in the selector for it to be considered
@@ -42,11 +190,22 @@ type FargateProfileSelector struct {
 
 // FargateProfileSpec defines the desired state of FargateProfile
 type FargateProfileSpec struct {
-	Region string `json:"region,required"`
+	// The AWS region the Amazon EKS cluster runs in.
+	// Defaults to the --default-region of the controller, the CR fails when neither is set.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// The name of the fargate-profile on AWS side. Defaults to the --profile-name-template of the controller,
+	// which is the name of the CR unless configured otherwise. Cannot be changed once set.
+	// +kubebuilder:validation:MaxLength=100
+	// +kubebuilder:validation:Pattern=`^[0-9A-Za-z][A-Za-z0-9\-_]*$`
+	// +optional
+	ProfileName string `json:"profileName,omitempty"`
 
 	// The name of the Amazon EKS cluster to apply the Fargate profile to.
-	// ClusterName is a required field
-	ClusterName string `json:"clusterName,required"`
+	// Defaults to the --default-cluster-name of the controller, the CR fails when neither is set.
+	// +optional
+	ClusterName string `json:"clusterName,omitempty"`
 
 	// The Amazon Resource Name (ARN) of the pod execution role to use for pods
 	// that match the selectors in the Fargate profile. The pod execution role allows
@@ -75,11 +234,129 @@ type FargateProfileSpec struct {
 	// with it.
 	// +optional
 	Tags map[string]string `json:"tags"`
+
+	// How the fargate-profile gets replaced when selectors, subnets or podExecutionRoleArn change.
+	// Recreate leaves a window where matching pods cannot be scheduled, BlueGreen avoids it
+	// by creating the new profile before deleting the old one.
+	// +kubebuilder:validation:Enum=Recreate;BlueGreen
+	// +optional
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// The ARN of an IAM role the controller assumes before making any AWS call for this profile.
+	// Allows a single controller to manage clusters in other AWS accounts.
+	// +optional
+	AssumeRoleArn string `json:"assumeRoleArn,omitempty"`
+
+	// The external ID to pass when assuming assumeRoleArn, if the role trust policy requires one.
+	// +optional
+	AssumeRoleExternalID string `json:"assumeRoleExternalId,omitempty"`
+
+	// A Secret in the namespace of the FargateProfile to read the AWS credentials from instead of the
+	// ambient credential chain of the controller. Changes to the Secret are picked up on the next reconcile,
+	// at the latest after the --audit-interval of the controller. The fargate-profile cannot be deleted without
+	// the Secret, so when both go away together, e.g. with their namespace, the FargateProfile waits for the
+	// Secret to be restored unless deletionPolicy is Retain.
+	// +optional
+	CredentialsSecretRef *CredentialsSecretRef `json:"credentialsSecretRef,omitempty"`
+
+	// What to do when a fargate-profile with the same name already exists and was not created by this CR.
+	// Adopt takes it over when its selectors, subnets and podExecutionRoleArn match the spec,
+	// Ignore only reports it in status and never changes or deletes it. Defaults to Fail.
+	// +kubebuilder:validation:Enum=Adopt;Fail;Ignore
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// What happens to the fargate-profile on AWS side when the CR is deleted. Retain orphans it,
+	// which is useful when moving profiles between controllers. Defaults to the --default-deletion-policy of the controller.
+	// +kubebuilder:validation:Enum=Delete;Retain
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// What happens when the fargate-profile on AWS side is changed outside of the controller. Heal replaces
+	// the profile and resets its tags, Report only surfaces the drift in status. A Ready profile deleted
+	// outside of the controller is recreated by Heal and marks the CR Failed with Report. Ready profiles are
+	// compared with AWS every --audit-interval of the controller. Defaults to Heal.
+	// +kubebuilder:validation:Enum=Report;Heal
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+}
+
+// FieldDrift is a field of the fargate-profile on AWS side that differs from the spec
+type FieldDrift struct {
+	// The spec field that drifted, e.g. selectors or tags.
+	Field string `json:"field"`
+	// The value the spec asks for.
+	// +optional
+	Expected string `json:"expected,omitempty"`
+	// The value found on AWS side.
+	// +optional
+	Actual string `json:"actual,omitempty"`
 }
 
 // FargateProfileStatus defines the observed state of FargateProfile
 type FargateProfileStatus struct {
 	Phase Phase `json:"phase"`
+
+	// The name of the fargate-profile on AWS side, resolved from spec.profileName or the naming template.
+	// +optional
+	FargateProfileName string `json:"fargateProfileName,omitempty"`
+
+	// The name of the fargate-profile a blue/green replacement is rolling out, until it replaces fargateProfileName.
+	// +optional
+	PendingFargateProfileName string `json:"pendingFargateProfileName,omitempty"`
+
+	// The name of the fargate-profile a blue/green replacement replaced, until AWS is done deleting it.
+	// +optional
+	ReplacedFargateProfileName string `json:"replacedFargateProfileName,omitempty"`
+
+	// The number of blue/green rollouts started, the fargate-profile each one creates is suffixed with it.
+	// +optional
+	BlueGreenRevision int64 `json:"blueGreenRevision,omitempty"`
+
+	// The ARN of the fargate-profile on AWS side.
+	// +optional
+	FargateProfileArn string `json:"fargateProfileArn,omitempty"`
+
+	// The status of the fargate-profile as reported by AWS, e.g. CREATING, ACTIVE or DELETE_FAILED.
+	// +optional
+	AwsStatus string `json:"awsStatus,omitempty"`
+
+	// When the fargate-profile was created on AWS side.
+	// +optional
+	CreatedAt *metav1.Time `json:"createdAt,omitempty"`
+
+	// The subnets the fargate-profile on AWS side launches pods into.
+	// +optional
+	Subnets []string `json:"subnets,omitempty"`
+
+	// The selectors of the fargate-profile on AWS side.
+	// +optional
+	Selectors []FargateProfileSelector `json:"selectors,omitempty"`
+
+	// The tags of the fargate-profile on AWS side.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// The tag keys that drifted from the spec on AWS side and were corrected during the last reconcile.
+	// +optional
+	TagDrift []string `json:"tagDrift,omitempty"`
+
+	// The fields of the fargate-profile on AWS side that were changed outside of the controller.
+	// +optional
+	Drift []FieldDrift `json:"drift,omitempty"`
+
+	// The generation of the spec last reconciled to Ready.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// A hash of the spec the fargate-profile on AWS side was last created from.
+	// +optional
+	LastAppliedSpecHash string `json:"lastAppliedSpecHash,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -88,6 +365,9 @@ type FargateProfileStatus struct {
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="selectors",type=string,JSONPath=`.spec.selectors`
 // +kubebuilder:printcolumn:name="phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="aws-status",type=string,JSONPath=`.status.awsStatus`
+// +kubebuilder:printcolumn:name="arn",type=string,JSONPath=`.status.fargateProfileArn`,priority=1
+// +kubebuilder:printcolumn:name="created-at",type=date,JSONPath=`.status.createdAt`,priority=1
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 type FargateProfile struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -110,13 +390,145 @@ func init() {
 	SchemeBuilder.Register(&FargateProfile{}, &FargateProfileList{})
 }
 
-func (in *FargateProfile) WithCreateIn() *eks.CreateFargateProfileInput {
+// CredentialsSecretKey returns the namespace/name of the referenced credentials Secret, if any
+func (in *FargateProfile) CredentialsSecretKey() (types.NamespacedName, bool) {
+	if in.Spec.CredentialsSecretRef == nil {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: in.GetNamespace(), Name: in.Spec.CredentialsSecretRef.Name}, true
+}
+
+// BlueGreenFargateProfileName returns the name of the profile the given blue/green rollout
+// creates to replace the current one when using the BlueGreen update strategy
+func (in *FargateProfile) BlueGreenFargateProfileName(baseName string, revision int64) string {
+	return FitFargateProfileName(fmt.Sprintf("%s-%d", baseName, revision))
+}
+
+// FitFargateProfileName turns any string into a valid fargate-profile name. Characters EKS does not allow
+// are replaced with -, and names over the length limit are truncated and suffixed with a hash of the full name
+// so that names sharing a long prefix stay unique.
+func FitFargateProfileName(name string) string {
+	fitted := []rune(strings.TrimLeft(invalidNameChars.ReplaceAllString(name, "-"), "-_"))
+	if len(fitted) > 0 && len(fitted) <= MaxFargateProfileNameLength {
+		return string(fitted)
+	}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:8]
+	if maxPrefix := MaxFargateProfileNameLength - len(hash) - 1; len(fitted) > maxPrefix {
+		fitted = fitted[:maxPrefix]
+	}
+	if len(fitted) == 0 {
+		return hash
+	}
+	return string(fitted) + "-" + hash
+}
+
+// SelectorKeys flattens each selector into a comparable string, so that the order
+// of selectors and labels does not matter when diffing them
+func SelectorKeys(selectors []FargateProfileSelector) []string {
+	var keys []string
+	for _, s := range selectors {
+		var labels []string
+		for k, v := range s.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		keys = append(keys, s.Namespace+"|"+strings.Join(labels, ","))
+	}
+	return keys
+}
+
+// EqualStringSets reports whether a and b hold the same strings, in any order
+func EqualStringSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]int{}
+	for _, ele := range a {
+		seen[ele]++
+	}
+	for _, ele := range b {
+		if seen[ele] == 0 {
+			return false
+		}
+		seen[ele]--
+	}
+	return true
+}
+
+// ManagedTags returns the tags that mark a fargate-profile as owned by this CR and controller instance
+func (in *FargateProfile) ManagedTags(controllerID string) map[string]string {
+	return map[string]string{
+		ManagedByTagKey:    ManagedByTagValue,
+		OwnerUIDTagKey:     string(in.GetUID()),
+		OwnerTagKey:        in.GetNamespace() + "/" + in.GetName(),
+		ControllerIDTagKey: controllerID,
+	}
+}
+
+// IsOwnerOf reports whether the tags of a fargate-profile on AWS side carry the managed tags of this CR
+func (in *FargateProfile) IsOwnerOf(tags map[string]string, controllerID string) bool {
+	for key, value := range in.ManagedTags(controllerID) {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// DesiredTags returns the tags the fargate-profile on AWS side should carry: spec.tags merged with the
+// controller default tags according to their precedence, protected default tags and then the managed tags
+func (in *FargateProfile) DesiredTags(controllerID string, defaults *DefaultTags) map[string]string {
+	tags := map[string]string{}
+	merge := func(from map[string]string) {
+		for key, value := range from {
+			tags[key] = value
+		}
+	}
+	if defaults == nil {
+		merge(in.Spec.Tags)
+	} else if defaults.Precedence == DefaultTagsFirst {
+		merge(in.Spec.Tags)
+		merge(defaults.Tags)
+	} else {
+		merge(defaults.Tags)
+		merge(in.Spec.Tags)
+	}
+	if defaults != nil {
+		for _, key := range defaults.ProtectedKeys {
+			if value, hasDefault := defaults.Tags[key]; hasDefault {
+				tags[key] = value
+			}
+		}
+	}
+	merge(in.ManagedTags(controllerID))
+	return tags
+}
+
+// ProtectedTagOverrides returns the spec.tags keys that try to override a protected default tag
+func (in *FargateProfile) ProtectedTagOverrides(defaults *DefaultTags) []string {
+	if defaults == nil {
+		return nil
+	}
+	var overrides []string
+	for _, key := range defaults.ProtectedKeys {
+		value, inSpec := in.Spec.Tags[key]
+		if !inSpec {
+			continue
+		}
+		if defaultValue, hasDefault := defaults.Tags[key]; !hasDefault || value != defaultValue {
+			overrides = append(overrides, key)
+		}
+	}
+	return overrides
+}
+
+func (in *FargateProfile) WithCreateIn(fargateProfileName, controllerID string, defaults *DefaultTags) *eks.CreateFargateProfileInput {
 
-	selectorsFn := func() []*eks.FargateProfileSelector {
-		var s []*eks.FargateProfileSelector
+	selectorsFn := func() []ekstypes.FargateProfileSelector {
+		var s []ekstypes.FargateProfileSelector
 		for _, inS := range in.Spec.Selectors {
-			s = append(s, &eks.FargateProfileSelector{
-				Labels:    aws.StringMap(inS.Labels),
+			s = append(s, ekstypes.FargateProfileSelector{
+				Labels:    inS.Labels,
 				Namespace: aws.String(inS.Namespace),
 			})
 		}
@@ -125,19 +537,19 @@ func (in *FargateProfile) WithCreateIn() *eks.CreateFargateProfileInput {
 
 	out := &eks.CreateFargateProfileInput{
 		ClusterName:         aws.String(in.Spec.ClusterName),
-		FargateProfileName:  aws.String(in.GetName()),
+		FargateProfileName:  aws.String(fargateProfileName),
 		PodExecutionRoleArn: aws.String(in.Spec.PodExecutionRoleArn),
 		Selectors:           selectorsFn(),
-		Subnets:             aws.StringSlice(in.Spec.Subnets),
-		Tags:                aws.StringMap(in.Spec.Tags),
+		Subnets:             in.Spec.Subnets,
+		Tags:                in.DesiredTags(controllerID, defaults),
 	}
 
 	return out
 }
 
-func (in *FargateProfile) WithDeleteIn() *eks.DeleteFargateProfileInput {
+func (in *FargateProfile) WithDeleteIn(fargateProfileName string) *eks.DeleteFargateProfileInput {
 	return &eks.DeleteFargateProfileInput{
 		ClusterName:        aws.String(in.Spec.ClusterName),
-		FargateProfileName: aws.String(in.GetName()),
+		FargateProfileName: aws.String(fargateProfileName),
 	}
 }
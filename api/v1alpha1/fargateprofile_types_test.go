@@ -0,0 +1,136 @@
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFitFargateProfileName(t *testing.T) {
+	longName := strings.Repeat("a", MaxFargateProfileNameLength+20)
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "valid name is kept", in: "web-profile_1", want: "web-profile_1"},
+		{name: "invalid characters are replaced", in: "default.web profile", want: "default-web-profile"},
+		{name: "leading separators are trimmed", in: "-_.web", want: "web"},
+		{name: "max length is kept", in: longName[:MaxFargateProfileNameLength], want: longName[:MaxFargateProfileNameLength]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FitFargateProfileName(tt.in); got != tt.want {
+				t.Errorf("FitFargateProfileName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("long names are truncated and stay unique", func(t *testing.T) {
+		first, second := FitFargateProfileName(longName+"-first"), FitFargateProfileName(longName+"-second")
+		for _, got := range []string{first, second} {
+			if len(got) > MaxFargateProfileNameLength || invalidNameChars.MatchString(got) {
+				t.Errorf("%q is not a valid fargate-profile name", got)
+			}
+		}
+		if first == second {
+			t.Errorf("expected different names, both are %q", first)
+		}
+	})
+
+	t.Run("names without valid characters get a hash", func(t *testing.T) {
+		if got := FitFargateProfileName("..."); got == "" || invalidNameChars.MatchString(got) {
+			t.Errorf("%q is not a valid fargate-profile name", got)
+		}
+	})
+}
+
+func taggedFargateProfile(tags map[string]string) *FargateProfile {
+	return &FargateProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-1"},
+		Spec:       FargateProfileSpec{Tags: tags},
+	}
+}
+
+func TestDesiredTags(t *testing.T) {
+	defaults := map[string]string{"cost-center": "1234", "owner": "platform"}
+	tests := []struct {
+		name     string
+		specTags map[string]string
+		defaults *DefaultTags
+		want     map[string]string
+	}{
+		{
+			name:     "spec tags only",
+			specTags: map[string]string{"team": "web"},
+			want:     map[string]string{"team": "web"},
+		},
+		{
+			name:     "spec tags win by default",
+			specTags: map[string]string{"owner": "web"},
+			defaults: &DefaultTags{Tags: defaults, Precedence: SpecTagsFirst},
+			want:     map[string]string{"cost-center": "1234", "owner": "web"},
+		},
+		{
+			name:     "default tags win",
+			specTags: map[string]string{"owner": "web", "team": "web"},
+			defaults: &DefaultTags{Tags: defaults, Precedence: DefaultTagsFirst},
+			want:     map[string]string{"cost-center": "1234", "owner": "platform", "team": "web"},
+		},
+		{
+			name:     "protected tags always win",
+			specTags: map[string]string{"cost-center": "9999", "owner": "web"},
+			defaults: &DefaultTags{Tags: defaults, Precedence: SpecTagsFirst, ProtectedKeys: []string{"cost-center"}},
+			want:     map[string]string{"cost-center": "1234", "owner": "web"},
+		},
+		{
+			name:     "managed tags cannot be overridden",
+			specTags: map[string]string{ManagedByTagKey: "someone-else"},
+			defaults: &DefaultTags{Tags: map[string]string{OwnerTagKey: "someone-else"}},
+			want:     map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := taggedFargateProfile(tt.specTags)
+			want := map[string]string{}
+			for key, value := range tt.want {
+				want[key] = value
+			}
+			for key, value := range fp.ManagedTags("ctrl") {
+				want[key] = value
+			}
+			if got := fp.DesiredTags("ctrl", tt.defaults); !reflect.DeepEqual(got, want) {
+				t.Errorf("DesiredTags() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestProtectedTagOverrides(t *testing.T) {
+	defaults := &DefaultTags{
+		Tags:          map[string]string{"cost-center": "1234"},
+		ProtectedKeys: []string{"cost-center", "compliance"},
+	}
+	tests := []struct {
+		name     string
+		specTags map[string]string
+		defaults *DefaultTags
+		want     []string
+	}{
+		{name: "no defaults", specTags: map[string]string{"cost-center": "9999"}},
+		{name: "no protected keys in spec", specTags: map[string]string{"team": "web"}, defaults: defaults},
+		{name: "same value as the default", specTags: map[string]string{"cost-center": "1234"}, defaults: defaults},
+		{name: "different value", specTags: map[string]string{"cost-center": "9999"}, defaults: defaults, want: []string{"cost-center"}},
+		{name: "protected key without default", specTags: map[string]string{"compliance": "none"}, defaults: defaults, want: []string{"compliance"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := taggedFargateProfile(tt.specTags).ProtectedTagOverrides(tt.defaults); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ProtectedTagOverrides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
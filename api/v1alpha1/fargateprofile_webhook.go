@@ -0,0 +1,235 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// limits documented at https://docs.aws.amazon.com/eks/latest/APIReference/API_CreateFargateProfile.html
+// and https://docs.aws.amazon.com/eks/latest/userguide/fargate-profile.html
+const (
+	maxLabelsPerSelector = 5
+	maxTags              = 50
+	maxTagKeyLength      = 128
+	maxTagValueLength    = 256
+	maxNameLength        = 100
+	reservedTagPrefix    = "aws:"
+)
+
+var (
+	fargateprofilelog = logf.Log.WithName("fargateprofile-resource")
+
+	roleArnRegex = regexp.MustCompile(`^arn:aws[a-zA-Z-]*:iam::[0-9]{12}:role/[\w+=,.@/-]+$`)
+	subnetRegex  = regexp.MustCompile(`^subnet-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	nameRegex    = regexp.MustCompile(`^[0-9A-Za-z][A-Za-z0-9\-_]*$`)
+	tagRegex     = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+)
+
+// defaults applied by the mutating webhook, set from the manager flags
+var (
+	defaultRegion      string
+	defaultClusterName string
+)
+
+// SetWebhookDefaults configures the region and cluster name the mutating webhook
+// sets on FargateProfiles that do not specify their own
+func SetWebhookDefaults(region, clusterName string) {
+	defaultRegion = region
+	defaultClusterName = clusterName
+}
+
+func (r *FargateProfile) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-agill-apps-eks-fargate-controller-v1alpha1-fargateprofile,mutating=true,failurePolicy=fail,groups=agill.apps.eks-fargate-controller,resources=fargateprofiles,verbs=create,versions=v1alpha1,name=mfargateprofile.kb.io,sideEffects=None,webhookVersions=v1beta1
+
+var _ webhook.Defaulter = &FargateProfile{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *FargateProfile) Default() {
+	fargateprofilelog.Info("default", "name", r.Name)
+
+	if r.Spec.Region == "" {
+		r.Spec.Region = defaultRegion
+	}
+	if r.Spec.ClusterName == "" {
+		r.Spec.ClusterName = defaultClusterName
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-agill-apps-eks-fargate-controller-v1alpha1-fargateprofile,mutating=false,failurePolicy=fail,groups=agill.apps.eks-fargate-controller,resources=fargateprofiles,versions=v1alpha1,name=vfargateprofile.kb.io,sideEffects=None,webhookVersions=v1beta1
+
+var _ webhook.Validator = &FargateProfile{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *FargateProfile) ValidateCreate() error {
+	fargateprofilelog.Info("validate create", "name", r.Name)
+
+	return r.toInvalidErr(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *FargateProfile) ValidateUpdate(old runtime.Object) error {
+	fargateprofilelog.Info("validate update", "name", r.Name)
+
+	oldFp, ok := old.(*FargateProfile)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a FargateProfile but got a %T", old))
+	}
+
+	// finalizer and metadata updates must go through, even for CRs created before the validation rules existed,
+	// otherwise the controller could not release a CR that is being deleted
+	if r.GetDeletionTimestamp() != nil || reflect.DeepEqual(r.Spec, oldFp.Spec) {
+		return nil
+	}
+
+	errs := r.validateSpec()
+	errs = append(errs, r.validateImmutableFields(oldFp)...)
+	return r.toInvalidErr(errs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *FargateProfile) ValidateDelete() error {
+	return nil
+}
+
+func (r *FargateProfile) validateSpec() field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if r.Spec.ProfileName != "" && (len(r.Spec.ProfileName) > MaxFargateProfileNameLength || !nameRegex.MatchString(r.Spec.ProfileName)) {
+		errs = append(errs, field.Invalid(specPath.Child("profileName"), r.Spec.ProfileName,
+			fmt.Sprintf("must be at most %d characters, start with a letter or digit and only contain letters, digits, - and _", MaxFargateProfileNameLength)))
+	}
+
+	if r.Spec.Region == "" {
+		errs = append(errs, field.Required(specPath.Child("region"), ""))
+	}
+
+	if r.Spec.ClusterName == "" {
+		errs = append(errs, field.Required(specPath.Child("clusterName"), ""))
+	} else if len(r.Spec.ClusterName) > maxNameLength || !nameRegex.MatchString(r.Spec.ClusterName) {
+		errs = append(errs, field.Invalid(specPath.Child("clusterName"), r.Spec.ClusterName, "not a valid eks cluster name"))
+	}
+
+	if !roleArnRegex.MatchString(r.Spec.PodExecutionRoleArn) {
+		errs = append(errs, field.Invalid(specPath.Child("podExecutionRoleArn"), r.Spec.PodExecutionRoleArn,
+			"must be an IAM role ARN like arn:aws:iam::123456789012:role/role-name"))
+	}
+
+	if r.Spec.AssumeRoleArn != "" && !roleArnRegex.MatchString(r.Spec.AssumeRoleArn) {
+		errs = append(errs, field.Invalid(specPath.Child("assumeRoleArn"), r.Spec.AssumeRoleArn,
+			"must be an IAM role ARN like arn:aws:iam::123456789012:role/role-name"))
+	}
+	if r.Spec.AssumeRoleExternalID != "" && r.Spec.AssumeRoleArn == "" {
+		errs = append(errs, field.Forbidden(specPath.Child("assumeRoleExternalId"), "can only be set along with assumeRoleArn"))
+	}
+
+	for idx, selector := range r.Spec.Selectors {
+		selectorPath := specPath.Child("selectors").Index(idx)
+		if strings.TrimSpace(selector.Namespace) == "" {
+			errs = append(errs, field.Required(selectorPath.Child("namespace"), ""))
+		}
+		if len(selector.Labels) > maxLabelsPerSelector {
+			errs = append(errs, field.TooMany(selectorPath.Child("labels"), len(selector.Labels), maxLabelsPerSelector))
+		}
+	}
+
+	for idx, subnet := range r.Spec.Subnets {
+		if !subnetRegex.MatchString(subnet) {
+			errs = append(errs, field.Invalid(specPath.Child("subnets").Index(idx), subnet, "not a valid subnet ID"))
+		}
+	}
+
+	tagsPath := specPath.Child("tags")
+	// the managed tags count against the aws limit too
+	if maxUserTags := maxTags - len(r.ManagedTags("")); len(r.Spec.Tags) > maxUserTags {
+		errs = append(errs, field.TooMany(tagsPath, len(r.Spec.Tags), maxUserTags))
+	}
+	for key, value := range r.Spec.Tags {
+		switch {
+		case key == "" || len(key) > maxTagKeyLength || !tagRegex.MatchString(key):
+			errs = append(errs, field.Invalid(tagsPath.Key(key), key,
+				fmt.Sprintf("tag keys must be 1 to %d characters of letters, digits, spaces and _.:/=+-@", maxTagKeyLength)))
+		case strings.HasPrefix(strings.ToLower(key), reservedTagPrefix):
+			errs = append(errs, field.Invalid(tagsPath.Key(key), key, fmt.Sprintf("the %s prefix is reserved for use by AWS", reservedTagPrefix)))
+		case strings.HasPrefix(key, ManagedTagPrefix):
+			errs = append(errs, field.Invalid(tagsPath.Key(key), key, fmt.Sprintf("the %s prefix is reserved for use by the controller", ManagedTagPrefix)))
+		}
+		if len(value) > maxTagValueLength || !tagRegex.MatchString(value) {
+			errs = append(errs, field.Invalid(tagsPath.Key(key), value,
+				fmt.Sprintf("tag values must be at most %d characters of letters, digits, spaces and _.:/=+-@", maxTagValueLength)))
+		}
+	}
+
+	return errs
+}
+
+// validateImmutableFields rejects changes that EKS cannot apply in place. Changing region or clusterName
+// once set would orphan the existing profile, the rest can only be rolled out through a replacement.
+func (r *FargateProfile) validateImmutableFields(old *FargateProfile) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	// CRs created while the webhooks were off may lack them, the controller fills them in with its defaults
+	if old.Spec.Region != "" && r.Spec.Region != old.Spec.Region {
+		errs = append(errs, field.Forbidden(specPath.Child("region"), "field is immutable"))
+	}
+	if old.Spec.ClusterName != "" && r.Spec.ClusterName != old.Spec.ClusterName {
+		errs = append(errs, field.Forbidden(specPath.Child("clusterName"), "field is immutable"))
+	}
+	if r.Spec.ProfileName != old.Spec.ProfileName {
+		errs = append(errs, field.Forbidden(specPath.Child("profileName"), "field is immutable"))
+	}
+
+	if r.Spec.UpdateStrategy != "" {
+		return errs
+	}
+	replaceMsg := fmt.Sprintf("field is immutable unless spec.updateStrategy is set to %s or %s", Recreate, BlueGreen)
+	if r.Spec.PodExecutionRoleArn != old.Spec.PodExecutionRoleArn {
+		errs = append(errs, field.Forbidden(specPath.Child("podExecutionRoleArn"), replaceMsg))
+	}
+	// like on AWS side, the order of subnets and selectors does not matter
+	if !EqualStringSets(r.Spec.Subnets, old.Spec.Subnets) {
+		errs = append(errs, field.Forbidden(specPath.Child("subnets"), replaceMsg))
+	}
+	if !EqualStringSets(SelectorKeys(r.Spec.Selectors), SelectorKeys(old.Spec.Selectors)) {
+		errs = append(errs, field.Forbidden(specPath.Child("selectors"), replaceMsg))
+	}
+	return errs
+}
+
+func (r *FargateProfile) toInvalidErr(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("FargateProfile").GroupKind(), r.GetName(), errs)
+}
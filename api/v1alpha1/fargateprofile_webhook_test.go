@@ -0,0 +1,158 @@
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func validFargateProfile() *FargateProfile {
+	return &FargateProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: FargateProfileSpec{
+			Region:              "us-east-1",
+			ClusterName:         "prod",
+			PodExecutionRoleArn: "arn:aws:iam::123456789012:role/fargate",
+			Subnets:             []string{"subnet-0123abcd", "subnet-4567cdef"},
+			Selectors: []FargateProfileSelector{
+				{Namespace: "web", Labels: map[string]string{"app": "web"}},
+				{Namespace: "jobs", Labels: map[string]string{}},
+			},
+			Tags: map[string]string{"team": "platform"},
+		},
+	}
+}
+
+func TestValidateSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		mutate    func(fp *FargateProfile)
+		wantField string
+	}{
+		{name: "valid", mutate: func(fp *FargateProfile) {}},
+		{name: "missing region", mutate: func(fp *FargateProfile) { fp.Spec.Region = "" }, wantField: "spec.region"},
+		{name: "missing cluster name", mutate: func(fp *FargateProfile) { fp.Spec.ClusterName = "" }, wantField: "spec.clusterName"},
+		{name: "invalid cluster name", mutate: func(fp *FargateProfile) { fp.Spec.ClusterName = "-prod" }, wantField: "spec.clusterName"},
+		{name: "role arn without account", mutate: func(fp *FargateProfile) { fp.Spec.PodExecutionRoleArn = "arn:aws:iam:::role/fargate" }, wantField: "spec.podExecutionRoleArn"},
+		{name: "external id without assume role", mutate: func(fp *FargateProfile) { fp.Spec.AssumeRoleExternalID = "id" }, wantField: "spec.assumeRoleExternalId"},
+		{name: "invalid profile name", mutate: func(fp *FargateProfile) { fp.Spec.ProfileName = "web.profile" }, wantField: "spec.profileName"},
+		{name: "profile name too long", mutate: func(fp *FargateProfile) { fp.Spec.ProfileName = strings.Repeat("a", MaxFargateProfileNameLength+1) }, wantField: "spec.profileName"},
+		{name: "selector without namespace", mutate: func(fp *FargateProfile) { fp.Spec.Selectors[0].Namespace = " " }, wantField: "spec.selectors[0].namespace"},
+		{name: "too many labels", mutate: func(fp *FargateProfile) {
+			fp.Spec.Selectors[0].Labels = map[string]string{"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6"}
+		}, wantField: "spec.selectors[0].labels"},
+		{name: "invalid subnet", mutate: func(fp *FargateProfile) { fp.Spec.Subnets[1] = "subnet-xyz" }, wantField: "spec.subnets[1]"},
+		{name: "aws tag prefix", mutate: func(fp *FargateProfile) { fp.Spec.Tags["aws:owner"] = "me" }, wantField: "spec.tags[aws:owner]"},
+		{name: "managed tag prefix", mutate: func(fp *FargateProfile) { fp.Spec.Tags[OwnerTagKey] = "me" }, wantField: "spec.tags[" + OwnerTagKey + "]"},
+		{name: "tag value too long", mutate: func(fp *FargateProfile) { fp.Spec.Tags["team"] = strings.Repeat("a", maxTagValueLength+1) }, wantField: "spec.tags[team]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := validFargateProfile()
+			tt.mutate(fp)
+			errs := fp.validateSpec()
+			if tt.wantField == "" {
+				if len(errs) != 0 {
+					t.Fatalf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || errs[0].Field != tt.wantField {
+				t.Fatalf("expected one error on %v, got %v", tt.wantField, errs)
+			}
+		})
+	}
+}
+
+func TestValidateImmutableFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		mutate     func(fp *FargateProfile)
+		wantFields []string
+	}{
+		{name: "tags can change", mutate: func(fp *FargateProfile) { fp.Spec.Tags["team"] = "data" }},
+		{name: "reordered subnets", mutate: func(fp *FargateProfile) {
+			fp.Spec.Subnets[0], fp.Spec.Subnets[1] = fp.Spec.Subnets[1], fp.Spec.Subnets[0]
+		}},
+		{name: "reordered selectors", mutate: func(fp *FargateProfile) {
+			fp.Spec.Selectors[0], fp.Spec.Selectors[1] = fp.Spec.Selectors[1], fp.Spec.Selectors[0]
+		}},
+		{name: "region", mutate: func(fp *FargateProfile) { fp.Spec.Region = "eu-west-1" }, wantFields: []string{"spec.region"}},
+		{name: "cluster name", mutate: func(fp *FargateProfile) { fp.Spec.ClusterName = "staging" }, wantFields: []string{"spec.clusterName"}},
+		{name: "region cleared", mutate: func(fp *FargateProfile) { fp.Spec.Region = "" }, wantFields: []string{"spec.region"}},
+		{name: "profile name", mutate: func(fp *FargateProfile) { fp.Spec.ProfileName = "web" }, wantFields: []string{"spec.profileName"}},
+		{name: "subnets without update strategy", mutate: func(fp *FargateProfile) {
+			fp.Spec.Subnets = []string{"subnet-0123abcd"}
+		}, wantFields: []string{"spec.subnets"}},
+		{name: "selector labels without update strategy", mutate: func(fp *FargateProfile) {
+			fp.Spec.Selectors[0].Labels["tier"] = "frontend"
+		}, wantFields: []string{"spec.selectors"}},
+		{name: "role without update strategy", mutate: func(fp *FargateProfile) {
+			fp.Spec.PodExecutionRoleArn = "arn:aws:iam::123456789012:role/other"
+		}, wantFields: []string{"spec.podExecutionRoleArn"}},
+		{name: "replaceable fields with update strategy", mutate: func(fp *FargateProfile) {
+			fp.Spec.UpdateStrategy = BlueGreen
+			fp.Spec.Subnets = []string{"subnet-0123abcd"}
+			fp.Spec.PodExecutionRoleArn = "arn:aws:iam::123456789012:role/other"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := validFargateProfile()
+			fp := validFargateProfile()
+			tt.mutate(fp)
+			errs := fp.validateImmutableFields(old)
+			if len(errs) != len(tt.wantFields) {
+				t.Fatalf("expected errors on %v, got %v", tt.wantFields, errs)
+			}
+			for idx, wantField := range tt.wantFields {
+				if errs[idx].Field != wantField {
+					t.Errorf("expected an error on %v, got %v", wantField, errs[idx])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateUpdateSkipsUnchangedSpec(t *testing.T) {
+	// CRs created before the webhook existed may not pass the validation rules
+	old := validFargateProfile()
+	old.Spec.PodExecutionRoleArn = "arn:aws:iam:::role/fargate"
+
+	finalizerOnly := old.DeepCopy()
+	finalizerOnly.Finalizers = []string{"fargate.finalizers.agill.apps"}
+	if err := finalizerOnly.ValidateUpdate(old); err != nil {
+		t.Errorf("expected a metadata-only update to be allowed, got %v", err)
+	}
+
+	deleting := old.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	deleting.Spec.Tags = map[string]string{"team": "data"}
+	if err := deleting.ValidateUpdate(old); err != nil {
+		t.Errorf("expected an update of a deleting CR to be allowed, got %v", err)
+	}
+
+	specChange := old.DeepCopy()
+	specChange.Spec.Tags = map[string]string{"team": "data"}
+	if err := specChange.ValidateUpdate(old); err == nil {
+		t.Error("expected a spec change to be validated")
+	}
+}
+
+func TestValidateUpdateAllowsControllerDefaults(t *testing.T) {
+	// a CR created while the webhooks were off gets its region and cluster name from the controller
+	old := validFargateProfile()
+	old.Spec.Region = ""
+	old.Spec.ClusterName = ""
+
+	defaulted := old.DeepCopy()
+	defaulted.Spec.Region = "us-east-1"
+	defaulted.Spec.ClusterName = "prod"
+	if err := defaulted.ValidateUpdate(old); err != nil {
+		t.Errorf("expected the controller defaults to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// isManagedFProfile reports whether the fargate-profile on AWS side was created or adopted by the CR.
+// Profiles created before the ownership tags existed are recognized by the name recorded in status,
+// which claimLegacyFProfileName fills in for CRs that predate status.fargateProfileName as well.
+func isManagedFProfile(cr *v1alpha1.FargateProfile, fp *types.FargateProfile, controllerID string) bool {
+	if _, hasOwner := fp.Tags[v1alpha1.OwnerUIDTagKey]; hasOwner {
+		return cr.IsOwnerOf(fp.Tags, controllerID)
+	}
+	return cr.Status.FargateProfileName != "" && cr.Status.FargateProfileName == aws.ToString(fp.FargateProfileName)
+}
+
+// isLegacyCR reports whether the CR was only ever reconciled by a controller version that named
+// fargate-profiles after the CR and did not record the name in status. That version set a phase but
+// no conditions, while every phase this controller sets comes with a condition.
+func isLegacyCR(cr *v1alpha1.FargateProfile) bool {
+	return cr.Status.Phase != "" && cr.Status.FargateProfileName == "" && len(cr.Status.Conditions) == 0
+}
+
+// claimLegacyFProfileName records the CR name a legacy CR named its fargate-profile after in status,
+// so the profile stays recognized once this controller starts setting conditions on the CR
+func claimLegacyFProfileName(cr *v1alpha1.FargateProfile) {
+	if isLegacyCR(cr) {
+		cr.Status.FargateProfileName = cr.GetName()
+	}
+}
+
+// claimUntaggedFProfile puts the ownership tags on a managed fargate-profile created before they existed
+// and records its name in status, so it is recognized even when the naming template changes
+func (r *FargateProfileReconciler) claimUntaggedFProfile(ctx context.Context, cr *v1alpha1.FargateProfile, fp *types.FargateProfile, eksClient EksAPI) error {
+	if _, hasOwner := fp.Tags[v1alpha1.OwnerUIDTagKey]; hasOwner {
+		return nil
+	}
+	fpName := aws.ToString(fp.FargateProfileName)
+	if errTagging := r.tagFProfileAsManaged(ctx, cr, fp, eksClient); errTagging != nil {
+		r.Log.Error(errTagging, fmt.Sprintf("Failed to tag fargate-profile %v as managed", fpName))
+		return typedAwsErr(errTagging)
+	}
+	cr.Status.FargateProfileName = fpName
+	if cr.Status.LastAppliedSpecHash == "" {
+		cr.Status.LastAppliedSpecHash = specHash(cr.Spec)
+	}
+	r.Log.Info(fmt.Sprintf("%s/%s: tagged fargate-profile %v as managed", cr.GetNamespace(), cr.GetName(), fpName))
+	return nil
+}
+
+// tagFProfileAsManaged puts the ownership tags of the CR on the fargate-profile and merges them into fp.Tags,
+// so the tags reconciled later on in the same reconcile do not report them as drift
+func (r *FargateProfileReconciler) tagFProfileAsManaged(ctx context.Context, cr *v1alpha1.FargateProfile, fp *types.FargateProfile, eksClient EksAPI) error {
+	managedTags := cr.ManagedTags(r.ControllerID)
+	if _, errTagging := eksClient.TagResource(ctx, &eks.TagResourceInput{
+		ResourceArn: fp.FargateProfileArn,
+		Tags:        managedTags,
+	}); errTagging != nil {
+		return errTagging
+	}
+	tags := map[string]string{}
+	for key, value := range fp.Tags {
+		tags[key] = value
+	}
+	for key, value := range managedTags {
+		tags[key] = value
+	}
+	fp.Tags = tags
+	return nil
+}
+
+// foreignOwner returns the namespace/name of another CR the ownership tags of the fargate-profile point to.
+// A profile tagged with the same namespace/name belongs to an earlier incarnation of the CR and can be adopted.
+func foreignOwner(cr *v1alpha1.FargateProfile, fp *types.FargateProfile) (string, bool) {
+	owner, hasOwner := fp.Tags[v1alpha1.OwnerTagKey]
+	if !hasOwner || owner == cr.GetNamespace()+"/"+cr.GetName() {
+		return "", false
+	}
+	return owner, true
+}
+
+// reconcileAdoption applies the adoption policy to a fargate-profile that already existed on AWS side.
+// It returns adopted=true when the profile is now managed by the CR and the reconcile can carry on.
+func (r *FargateProfileReconciler) reconcileAdoption(ctx context.Context, cr *v1alpha1.FargateProfile, fp *types.FargateProfile, eksClient EksAPI) (ctrl.Result, bool, error) {
+	crName := fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName())
+	fpName := aws.ToString(fp.FargateProfileName)
+
+	if owner, ownedByOther := foreignOwner(cr, fp); ownedByOther && cr.Spec.AdoptionPolicy != v1alpha1.Ignore {
+		errNotOwned := ErrFargateProfileNotOwned{Message: fmt.Sprintf("%v fargate-profile already exists and is owned by %v", fpName, owner)}
+		r.Log.Info(fmt.Sprintf("%s: %v", crName, errNotOwned.Message))
+		r.Recorder.Event(cr, corev1.EventTypeWarning, errNotOwned.Reason(), errNotOwned.Error())
+		setConditionFromErr(cr, v1alpha1.Adopted, errNotOwned)
+		setConditionFromErr(cr, v1alpha1.Synced, errNotOwned)
+		updateCrPhase(v1alpha1.Failed, cr)
+		return ctrl.Result{}, false, nil
+	}
+
+	switch cr.Spec.AdoptionPolicy {
+	case v1alpha1.Ignore:
+		setAwsStatus(cr, fp)
+		setCondition(cr, v1alpha1.Adopted, metav1.ConditionFalse, "Ignored",
+			fmt.Sprintf("%v fargate-profile already exists and is left untouched because adoptionPolicy is %v", fpName, v1alpha1.Ignore))
+		r.Log.Info(fmt.Sprintf("%s: fargate-profile %v already exists, ignoring it", crName, fpName))
+		updateCrPhase(v1alpha1.Unmanaged, cr)
+		return ctrl.Result{}, false, nil
+
+	case v1alpha1.Adopt:
+		if fProfileNeedsReplacement(cr.WithCreateIn(fpName, r.ControllerID, r.DefaultTags), fp) {
+			return r.refuseAdoption(cr, ErrFargateProfileNotAdoptable{Message: fmt.Sprintf("%v fargate-profile already exists "+
+				"but its selectors, subnets or podExecutionRoleArn do not match the spec", fpName)})
+		}
+		if errTagging := r.tagFProfileAsManaged(ctx, cr, fp, eksClient); errTagging != nil {
+			r.Log.Error(errTagging, fmt.Sprintf("Failed to tag fargate-profile %v as managed", fpName))
+			setCondition(cr, v1alpha1.Adopted, metav1.ConditionUnknown, "TaggingFailed", errTagging.Error())
+			return ctrl.Result{}, false, typedAwsErr(errTagging)
+		}
+		cr.Status.FargateProfileName = fpName
+		cr.Status.LastAppliedSpecHash = specHash(cr.Spec)
+		setCondition(cr, v1alpha1.Adopted, metav1.ConditionTrue, "Adopted", fmt.Sprintf("%v fargate-profile existed and was adopted", fpName))
+		r.Log.Info(fmt.Sprintf("%s: adopted existing fargate-profile %v", crName, fpName))
+		r.Recorder.Event(cr, corev1.EventTypeNormal, "Adopted", fmt.Sprintf("Adopted existing fargate-profile %v", fpName))
+		return ctrl.Result{}, true, nil
+
+	default:
+		return r.refuseAdoption(cr, ErrFargateProfileNotAdoptable{Message: fmt.Sprintf("%v fargate-profile already exists "+
+			"and was not created by this CR, set adoptionPolicy to %v to take it over", fpName, v1alpha1.Adopt)})
+	}
+}
+
+func (r *FargateProfileReconciler) refuseAdoption(cr *v1alpha1.FargateProfile, errNotAdoptable ErrFargateProfileNotAdoptable) (ctrl.Result, bool, error) {
+	r.Log.Info(fmt.Sprintf("%s/%s: %v", cr.GetNamespace(), cr.GetName(), errNotAdoptable.Message))
+	r.Recorder.Event(cr, corev1.EventTypeWarning, errNotAdoptable.Reason(), errNotAdoptable.Error())
+	setConditionFromErr(cr, v1alpha1.Adopted, errNotAdoptable)
+	setConditionFromErr(cr, v1alpha1.Synced, errNotAdoptable)
+	updateCrPhase(v1alpha1.Failed, cr)
+	return ctrl.Result{}, false, nil
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// existingProfile is an ACTIVE fargate-profile created outside of the controller, e.g. by eksctl
+func existingProfile(name string, selectorNamespace string, tags map[string]string) *types.FargateProfile {
+	return &types.FargateProfile{
+		FargateProfileName:  aws.String(name),
+		FargateProfileArn:   aws.String("arn:aws:eks:us-east-1:123456789012:fargateprofile/prod/" + name),
+		PodExecutionRoleArn: aws.String("arn:aws:iam::123456789012:role/fargate"),
+		Subnets:             []string{"subnet-0123abcd", "subnet-4567cdef"},
+		Selectors:           []types.FargateProfileSelector{{Namespace: aws.String(selectorNamespace), Labels: map[string]string{"app": "web"}}},
+		Tags:                tags,
+		Status:              types.FargateProfileStatusActive,
+	}
+}
+
+func TestReconcileRefusesExistingProfile(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.eks.profiles["web"] = existingProfile("web", "eksctl", map[string]string{"created-by": "eksctl"})
+
+	for i := 0; i < 3; i++ {
+		cr := rt.reconcile()
+		if cr.Status.Phase != v1alpha1.Failed || cr.Status.FargateProfileName != "" {
+			t.Fatalf("reconcile %d: expected the CR to fail without claiming the fargate-profile, got phase %v and name %q",
+				i, cr.Status.Phase, cr.Status.FargateProfileName)
+		}
+		fp := rt.eks.profiles["web"]
+		if fp.Status != types.FargateProfileStatusActive || len(fp.Tags) != 1 {
+			t.Fatalf("reconcile %d: expected the fargate-profile to be left alone, got %v with tags %v", i, fp.Status, fp.Tags)
+		}
+	}
+	if cond := findCondition(rt.get(), v1alpha1.Adopted); cond == nil || cond.Reason != (ErrFargateProfileNotAdoptable{}).Reason() {
+		t.Errorf("expected the Adopted condition to report the refusal, got %+v", cond)
+	}
+}
+
+func TestReconcileAdoptsExistingProfile(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.AdoptionPolicy = v1alpha1.Adopt
+	})
+	rt.eks.profiles["web"] = existingProfile("web", "web", map[string]string{"team": "web"})
+
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Ready || cr.Status.FargateProfileName != "web" {
+		t.Fatalf("expected the fargate-profile to be adopted, got phase %v and name %q", cr.Status.Phase, cr.Status.FargateProfileName)
+	}
+	if !cr.IsOwnerOf(rt.eks.profiles["web"].Tags, "test") {
+		t.Errorf("expected the adopted fargate-profile to be tagged, got %v", rt.eks.profiles["web"].Tags)
+	}
+	events := rt.events()
+	if !hasEvent(events, corev1.EventTypeNormal, "Adopted") {
+		t.Error("expected an Adopted event")
+	}
+	// the ownership tags were just put there by the adoption, they are no drift
+	if len(cr.Status.TagDrift) != 0 || hasEvent(events, corev1.EventTypeNormal, "TagsUpdated") {
+		t.Errorf("expected no tag drift after the adoption, got %v and events %v", cr.Status.TagDrift, events)
+	}
+}
+
+func TestReconcileRefusesToAdoptMismatchingProfile(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.AdoptionPolicy = v1alpha1.Adopt
+	})
+	rt.eks.profiles["web"] = existingProfile("web", "eksctl", map[string]string{})
+
+	for i := 0; i < 2; i++ {
+		cr := rt.reconcile()
+		if cr.Status.Phase != v1alpha1.Failed || cr.Status.FargateProfileName != "" {
+			t.Fatalf("reconcile %d: expected the adoption to be refused, got phase %v and name %q", i, cr.Status.Phase, cr.Status.FargateProfileName)
+		}
+		if fp := rt.eks.profiles["web"]; fp.Status != types.FargateProfileStatusActive || len(fp.Tags) != 0 {
+			t.Fatalf("reconcile %d: expected the fargate-profile to be left alone, got %v with tags %v", i, fp.Status, fp.Tags)
+		}
+	}
+}
+
+func TestReconcileIgnoresExistingProfile(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.AdoptionPolicy = v1alpha1.Ignore
+	})
+	rt.eks.profiles["web"] = existingProfile("web", "eksctl", map[string]string{})
+
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Unmanaged || cr.Status.FargateProfileArn != aws.ToString(rt.eks.profiles["web"].FargateProfileArn) {
+		t.Fatalf("expected the fargate-profile to be reported as %v, got phase %v and arn %q", v1alpha1.Unmanaged, cr.Status.Phase, cr.Status.FargateProfileArn)
+	}
+	if fp := rt.eks.profiles["web"]; fp.Status != types.FargateProfileStatusActive || len(fp.Tags) != 0 {
+		t.Fatalf("expected the fargate-profile to be left alone, got %v with tags %v", fp.Status, fp.Tags)
+	}
+}
+
+func TestReconcileRefusesProfileOwnedByAnotherCR(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.AdoptionPolicy = v1alpha1.Adopt
+	})
+	otherTags := map[string]string{
+		v1alpha1.ManagedByTagKey:    v1alpha1.ManagedByTagValue,
+		v1alpha1.OwnerUIDTagKey:     "uid-other",
+		v1alpha1.OwnerTagKey:        "other/web",
+		v1alpha1.ControllerIDTagKey: "test",
+	}
+	rt.eks.profiles["web"] = existingProfile("web", "web", otherTags)
+
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Failed {
+		t.Fatalf("expected the CR to fail, got phase %v", cr.Status.Phase)
+	}
+	if cond := findCondition(cr, v1alpha1.Adopted); cond == nil || cond.Reason != (ErrFargateProfileNotOwned{}).Reason() {
+		t.Errorf("expected the Adopted condition to name the other owner, got %+v", cond)
+	}
+	if !hasEvent(rt.events(), corev1.EventTypeWarning, (ErrFargateProfileNotOwned{}).Reason()) {
+		t.Error("expected a FargateProfileNotOwned event")
+	}
+
+	// deleting the CR must not delete the profile of the other CR
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	if cr = rt.reconcile(); len(cr.GetFinalizers()) != 0 {
+		t.Fatalf("expected the finalizer to be released, got %v", cr.GetFinalizers())
+	}
+	if fp := rt.eks.profiles["web"]; fp.Status != types.FargateProfileStatusActive || fp.Tags[v1alpha1.OwnerTagKey] != "other/web" {
+		t.Errorf("expected the fargate-profile of the other CR to be left alone, got %v with tags %v", fp.Status, fp.Tags)
+	}
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/smithy-go"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// typedAwsErr converts the AWS errors that get a dedicated handling into the typed errors above,
+// any other error is returned as is
+func typedAwsErr(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "ResourceInUseException":
+		return ErrResourceInUse{Message: err.Error(), Err: err}
+	case "ResourceLimitExceededException", "LimitExceededException":
+		return ErrLimitExceeded{Message: err.Error(), Err: err}
+	case "InvalidParameterException", "InvalidParameterValue", "InvalidInput":
+		return ErrInvalidParameter{Message: err.Error(), Err: err}
+	case "AccessDeniedException", "AccessDenied", "UnauthorizedOperation":
+		return ErrAccessDenied{Message: err.Error(), Err: err}
+	}
+	return err
+}
+
+func isHandledAwsErr(err error) bool {
+	switch err.(type) {
+	case ErrResourceInUse, ErrLimitExceeded, ErrInvalidParameter, ErrAccessDenied:
+		return true
+	}
+	return false
+}
+
+// handleAwsErr records one of the typed AWS errors on the CR and picks how to requeue.
+// Conflicts clear up by themselves, quotas and permissions are fixed outside the cluster
+// so they are retried slowly, invalid parameters need a spec change which triggers a reconcile anyway.
+func (r *FargateProfileReconciler) handleAwsErr(cr *v1alpha1.FargateProfile, err error) (ctrl.Result, bool) {
+	var result ctrl.Result
+	var phase v1alpha1.Phase
+	switch err.(type) {
+	case ErrResourceInUse:
+		result = ctrl.Result{RequeueAfter: 30 * time.Second}
+	case ErrLimitExceeded:
+		phase, result = v1alpha1.Failed, ctrl.Result{RequeueAfter: 10 * time.Minute}
+	case ErrAccessDenied:
+		phase, result = v1alpha1.Failed, ctrl.Result{RequeueAfter: 5 * time.Minute}
+	case ErrInvalidParameter:
+		phase = v1alpha1.Failed
+	default:
+		return ctrl.Result{}, false
+	}
+
+	reason, _ := errReason(err)
+	r.Log.Info(fmt.Sprintf("%s/%s: %v: %v", cr.GetNamespace(), cr.GetName(), reason, err))
+	r.Recorder.Event(cr, corev1.EventTypeWarning, reason, err.Error())
+	setConditionFromErr(cr, v1alpha1.Synced, err)
+	if phase != "" {
+		cr.Status.Phase = phase
+	}
+	return result, true
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/smithy-go"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestTypedAwsErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "resource in use", err: &types.ResourceInUseException{Message: aws.String("busy")}, want: ErrResourceInUse{}},
+		{name: "eks profile limit", err: &types.ResourceLimitExceededException{Message: aws.String("10 profiles")}, want: ErrLimitExceeded{}},
+		{name: "generic limit", err: &smithy.GenericAPIError{Code: "LimitExceededException"}, want: ErrLimitExceeded{}},
+		{name: "eks invalid parameter", err: &types.InvalidParameterException{Message: aws.String("bad subnet")}, want: ErrInvalidParameter{}},
+		{name: "ec2 invalid parameter", err: &smithy.GenericAPIError{Code: "InvalidParameterValue"}, want: ErrInvalidParameter{}},
+		{name: "eks access denied", err: &smithy.GenericAPIError{Code: "AccessDeniedException"}, want: ErrAccessDenied{}},
+		{name: "ec2 unauthorized", err: &smithy.GenericAPIError{Code: "UnauthorizedOperation"}, want: ErrAccessDenied{}},
+		{name: "other api error", err: &types.ServerException{Message: aws.String("boom")}},
+		{name: "not an api error", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := typedAwsErr(tt.err)
+			if tt.want == nil {
+				if got != tt.err {
+					t.Fatalf("expected %v to be returned as is, got %#v", tt.err, got)
+				}
+				return
+			}
+			if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+				t.Fatalf("expected %T, got %#v", tt.want, got)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("expected %T to wrap %v", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestReconcileHandlesAwsErr(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+		phase  v1alpha1.Phase
+		result ctrl.Result
+	}{
+		{name: "in use is retried soon", err: &types.ResourceInUseException{Message: aws.String("busy")},
+			reason: "ResourceInUse", result: ctrl.Result{RequeueAfter: 30 * time.Second}},
+		{name: "limit is retried slowly", err: &types.ResourceLimitExceededException{Message: aws.String("10 profiles")},
+			reason: "LimitExceeded", phase: v1alpha1.Failed, result: ctrl.Result{RequeueAfter: 10 * time.Minute}},
+		{name: "access denied is retried slowly", err: &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "denied"},
+			reason: "AccessDenied", phase: v1alpha1.Failed, result: ctrl.Result{RequeueAfter: 5 * time.Minute}},
+		{name: "invalid parameter waits for a spec change", err: &types.InvalidParameterException{Message: aws.String("bad subnet")},
+			reason: "InvalidParameter", phase: v1alpha1.Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReconcileTest(t, "")
+			rt.eks.createErr = tt.err
+
+			result, cr := rt.reconcileResult()
+			if result != tt.result {
+				t.Errorf("expected %+v, got %+v", tt.result, result)
+			}
+			if cr.Status.Phase != tt.phase {
+				t.Errorf("expected phase %q, got %q", tt.phase, cr.Status.Phase)
+			}
+			if cond := findCondition(cr, v1alpha1.Synced); cond == nil || cond.Reason != tt.reason {
+				t.Errorf("expected the Synced condition with reason %v, got %+v", tt.reason, cond)
+			}
+			if !hasEvent(rt.events(), corev1.EventTypeWarning, tt.reason) {
+				t.Errorf("expected a %v event", tt.reason)
+			}
+		})
+	}
+}
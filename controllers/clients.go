@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// EksAPI is the part of the eks client the controller uses
+type EksAPI interface {
+	DescribeCluster(ctx context.Context, in *eks.DescribeClusterInput, optFns ...func(*eks.Options)) (*eks.DescribeClusterOutput, error)
+	DescribeFargateProfile(ctx context.Context, in *eks.DescribeFargateProfileInput, optFns ...func(*eks.Options)) (*eks.DescribeFargateProfileOutput, error)
+	CreateFargateProfile(ctx context.Context, in *eks.CreateFargateProfileInput, optFns ...func(*eks.Options)) (*eks.CreateFargateProfileOutput, error)
+	DeleteFargateProfile(ctx context.Context, in *eks.DeleteFargateProfileInput, optFns ...func(*eks.Options)) (*eks.DeleteFargateProfileOutput, error)
+	TagResource(ctx context.Context, in *eks.TagResourceInput, optFns ...func(*eks.Options)) (*eks.TagResourceOutput, error)
+	UntagResource(ctx context.Context, in *eks.UntagResourceInput, optFns ...func(*eks.Options)) (*eks.UntagResourceOutput, error)
+}
+
+// Ec2API is the part of the ec2 client the controller uses
+type Ec2API interface {
+	DescribeRouteTables(ctx context.Context, in *ec2.DescribeRouteTablesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRouteTablesOutput, error)
+	DescribeSubnets(ctx context.Context, in *ec2.DescribeSubnetsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error)
+}
+
+// IamAPI is the part of the iam client the controller uses
+type IamAPI interface {
+	GetRole(ctx context.Context, in *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
+}
+
+// AwsClients are the aws clients needed to reconcile a FargateProfile
+type AwsClients struct {
+	Eks EksAPI
+	Ec2 Ec2API
+	Iam IamAPI
+}
+
+// AwsClientFactory hands out the aws clients for a session config, tests can swap it with fakes
+type AwsClientFactory interface {
+	ClientsFor(ctx context.Context, cfg AwsSessionConfig) (AwsClients, error)
+}
+
+// clientCacheKey identifies who the clients talk to AWS as, without the credentials themselves
+type clientCacheKey struct {
+	region            string
+	credentialsSource string
+	assumeRoleArn     string
+	externalID        string
+}
+
+type cachedClients struct {
+	cfg     AwsSessionConfig
+	clients AwsClients
+}
+
+// cachedAwsClientFactory shares one aws config and set of clients per region and identity
+// across reconciles instead of building them on every reconcile
+type cachedAwsClientFactory struct {
+	retry   RetryConfig
+	mu      sync.Mutex
+	entries map[clientCacheKey]cachedClients
+}
+
+func NewCachedAwsClientFactory(retry RetryConfig) AwsClientFactory {
+	return &cachedAwsClientFactory{retry: retry, entries: map[clientCacheKey]cachedClients{}}
+}
+
+func (f *cachedAwsClientFactory) ClientsFor(ctx context.Context, cfg AwsSessionConfig) (AwsClients, error) {
+	key := clientCacheKey{
+		region:            cfg.Region,
+		credentialsSource: cfg.CredentialsSource,
+		assumeRoleArn:     cfg.AssumeRoleArn,
+		externalID:        cfg.ExternalID,
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// a different config under the same key means the credentials in the secret were rotated
+	if entry, found := f.entries[key]; found && entry.cfg == cfg {
+		return entry.clients, nil
+	}
+
+	awsCfg, errLoadingConfig := newAwsConfig(ctx, cfg, f.retry)
+	if errLoadingConfig != nil {
+		return AwsClients{}, errLoadingConfig
+	}
+	clients := AwsClients{
+		Eks: eks.NewFromConfig(awsCfg),
+		Ec2: ec2.NewFromConfig(awsCfg),
+		Iam: iam.NewFromConfig(awsCfg),
+	}
+	f.entries[key] = cachedClients{cfg: cfg, clients: clients}
+	return clients, nil
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCachedAwsClientFactory(t *testing.T) {
+	// keep the shared config of whoever runs the tests out of the loaded configs
+	t.Setenv("AWS_CONFIG_FILE", "/nonexistent")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/nonexistent")
+
+	factory := NewCachedAwsClientFactory(RetryConfig{MaxRetries: 1, BaseDelay: time.Second, MaxDelay: time.Minute})
+	cfg := AwsSessionConfig{
+		Region:            "us-east-1",
+		CredentialsSource: "default/aws-creds",
+		AccessKeyID:       "AKIAOLD",
+		SecretAccessKey:   "old",
+	}
+	clientsFor := func(cfg AwsSessionConfig) AwsClients {
+		clients, err := factory.ClientsFor(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("ClientsFor(%+v) returned %v", cfg, err)
+		}
+		return clients
+	}
+
+	first := clientsFor(cfg)
+	if again := clientsFor(cfg); again.Eks != first.Eks || again.Ec2 != first.Ec2 || again.Iam != first.Iam {
+		t.Error("expected the clients to be reused for the same config")
+	}
+
+	otherRegion := cfg
+	otherRegion.Region = "eu-west-1"
+	if clients := clientsFor(otherRegion); clients.Eks == first.Eks {
+		t.Error("expected other clients for another region")
+	}
+	if again := clientsFor(cfg); again.Eks != first.Eks {
+		t.Error("expected the clients of the first region to stay cached")
+	}
+
+	// the secret was rotated, the clients built with the old keys must not be handed out again
+	rotated := cfg
+	rotated.AccessKeyID, rotated.SecretAccessKey = "AKIANEW", "new"
+	rotatedClients := clientsFor(rotated)
+	if rotatedClients.Eks == first.Eks {
+		t.Error("expected new clients after the credentials changed")
+	}
+	if again := clientsFor(rotated); again.Eks != rotatedClients.Eks {
+		t.Error("expected the clients for the rotated credentials to be reused")
+	}
+	if entries := len(factory.(*cachedAwsClientFactory).entries); entries != 2 {
+		t.Errorf("expected the clients for the old credentials to be evicted, got %d entries", entries)
+	}
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// clusterLockTTL bounds how long a CR can block a cluster without coming back to it,
+// so a CR stuck in a failed state does not hold up every other profile of the cluster
+const clusterLockTTL = 20 * time.Minute
+
+// clusterLockRetryInterval is how often a queued CR checks whether the cluster became available
+const clusterLockRetryInterval = 15 * time.Second
+
+type clusterLockHolder struct {
+	owner    types.NamespacedName
+	acquired time.Time
+}
+
+// ClusterLocks serializes the fargate-profile creates and deletes of each eks cluster.
+// EKS rejects a create or delete while another profile of the cluster is CREATING or DELETING,
+// so a CR holds the lock of its cluster from its first mutating call until its profile settles.
+type ClusterLocks struct {
+	mu      sync.Mutex
+	holders map[string]clusterLockHolder
+}
+
+func NewClusterLocks() *ClusterLocks {
+	return &ClusterLocks{holders: map[string]clusterLockHolder{}}
+}
+
+func clusterLockKey(fp *v1alpha1.FargateProfile) string {
+	return fp.Spec.Region + "/" + fp.Spec.ClusterName
+}
+
+func clusterLockOwner(fp *v1alpha1.FargateProfile) types.NamespacedName {
+	return types.NamespacedName{Namespace: fp.GetNamespace(), Name: fp.GetName()}
+}
+
+// TryAcquire takes the lock of the cluster for owner. When another CR holds it, that CR is returned.
+// Taking the lock again does not extend it, so a holder that keeps retrying without settling its profile
+// still lets the other CRs of the cluster go once the TTL ran out.
+func (l *ClusterLocks) TryAcquire(cluster string, owner types.NamespacedName) (types.NamespacedName, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	holder, held := l.holders[cluster]
+	if held && time.Since(holder.acquired) < clusterLockTTL {
+		if holder.owner != owner {
+			return holder.owner, false
+		}
+		return owner, true
+	}
+	l.holders[cluster] = clusterLockHolder{owner: owner, acquired: time.Now()}
+	return owner, true
+}
+
+// Release gives up every cluster lock held by owner
+func (l *ClusterLocks) Release(owner types.NamespacedName) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for cluster, holder := range l.holders {
+		if holder.owner == owner {
+			delete(l.holders, cluster)
+		}
+	}
+}
+
+// acquireClusterLock takes the lock of the cluster of the CR before a mutating eks call.
+// When another CR is busy with the cluster, the CR is marked Queued and false is returned.
+func (r *FargateProfileReconciler) acquireClusterLock(cr *v1alpha1.FargateProfile) bool {
+	if r.ClusterLocks == nil {
+		return true
+	}
+	owner := clusterLockOwner(cr)
+	holder, acquired := r.ClusterLocks.TryAcquire(clusterLockKey(cr), owner)
+	if !acquired {
+		setCondition(cr, v1alpha1.Queued, metav1.ConditionTrue, "WaitingForCluster",
+			fmt.Sprintf("waiting for %v to finish its fargate-profile operation on %v eks cluster", holder, cr.Spec.ClusterName))
+		r.Log.Info(fmt.Sprintf("%s: waiting for %v to finish its fargate-profile operation on %v eks cluster", owner, holder, cr.Spec.ClusterName))
+		return false
+	}
+	setCondition(cr, v1alpha1.Queued, metav1.ConditionFalse, "ClusterAvailable",
+		fmt.Sprintf("no other fargate-profile operation is running on %v eks cluster", cr.Spec.ClusterName))
+	return true
+}
+
+// releaseClusterLock lets the next CR of the cluster go once the profile of the CR settled
+func (r *FargateProfileReconciler) releaseClusterLock(nsName types.NamespacedName) {
+	if r.ClusterLocks == nil {
+		return
+	}
+	r.ClusterLocks.Release(nsName)
+}
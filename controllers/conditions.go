@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"strings"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setCondition adds or updates the condition of the given type.
+// LastTransitionTime only moves when the status of the condition changes.
+func setCondition(fp *v1alpha1.FargateProfile, condType v1alpha1.ConditionType, status metav1.ConditionStatus, reason, message string) {
+	newCond := v1alpha1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: fp.GetGeneration(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+
+	for idx, cond := range fp.Status.Conditions {
+		if cond.Type != condType {
+			continue
+		}
+		if cond.Status == status {
+			newCond.LastTransitionTime = cond.LastTransitionTime
+		}
+		fp.Status.Conditions[idx] = newCond
+		return
+	}
+	fp.Status.Conditions = append(fp.Status.Conditions, newCond)
+}
+
+// findCondition returns the condition of the given type, nil when it was never set
+func findCondition(fp *v1alpha1.FargateProfile, condType v1alpha1.ConditionType) *v1alpha1.Condition {
+	for idx := range fp.Status.Conditions {
+		if fp.Status.Conditions[idx].Type == condType {
+			return &fp.Status.Conditions[idx]
+		}
+	}
+	return nil
+}
+
+// setConditionFromErr marks the condition False using the reason of one of the typed errors,
+// anything else means the check could not be run so the condition is Unknown
+func setConditionFromErr(fp *v1alpha1.FargateProfile, condType v1alpha1.ConditionType, err error) {
+	if reason, ok := errReason(err); ok {
+		setCondition(fp, condType, metav1.ConditionFalse, reason, err.Error())
+		return
+	}
+	setCondition(fp, condType, metav1.ConditionUnknown, "CheckFailed", err.Error())
+}
+
+// errReason returns the reason of one of the typed errors from errors.go
+func errReason(err error) (string, bool) {
+	if e, ok := err.(interface{ Reason() string }); ok {
+		return e.Reason(), true
+	}
+	return "", false
+}
+
+// awsStatusToReason converts fargate-profile statuses like CREATE_FAILED to CreateFailed
+func awsStatusToReason(status string) string {
+	var reason string
+	for _, word := range strings.Split(strings.ToLower(status), "_") {
+		if word == "" {
+			continue
+		}
+		reason += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return reason
+}
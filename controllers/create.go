@@ -1,15 +1,18 @@
 package controllers
 
 import (
-	"github.com/aws/aws-sdk-go/service/eks"
-	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
 )
 
-func createFProfile(input *eks.CreateFargateProfileInput, eksClient eksiface.EKSAPI) error {
+func createFProfile(ctx context.Context, input *eks.CreateFargateProfileInput, eksClient EksAPI) (*types.FargateProfile, error) {
 
-	if _, errCreatingFargateProfile := eksClient.CreateFargateProfile(input); errCreatingFargateProfile != nil {
-		return errCreatingFargateProfile
+	out, errCreatingFargateProfile := eksClient.CreateFargateProfile(ctx, input)
+	if errCreatingFargateProfile != nil {
+		return nil, typedAwsErr(errCreatingFargateProfile)
 	}
 
-	return nil
+	return out.FargateProfile, nil
 }
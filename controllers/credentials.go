@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultAccessKeyIDKey     = "AWS_ACCESS_KEY_ID"
+	defaultSecretAccessKeyKey = "AWS_SECRET_ACCESS_KEY"
+	defaultSessionTokenKey    = "AWS_SESSION_TOKEN"
+)
+
+// loadSecretCredentials fills cfg with the credentials from the Secret referenced by fp, if any.
+// c should be an uncached reader, a cached client would keep every Secret of the cluster in memory.
+func loadSecretCredentials(c client.Reader, fp *v1alpha1.FargateProfile, cfg *AwsSessionConfig) error {
+	secretKey, hasSecretRef := fp.CredentialsSecretKey()
+	if !hasSecretRef {
+		return nil
+	}
+
+	secret := &corev1.Secret{}
+	if errGettingSecret := c.Get(context.TODO(), secretKey, secret); errGettingSecret != nil {
+		if errors.IsNotFound(errGettingSecret) {
+			return ErrInvalidCredentialsSecret{Message: fmt.Sprintf("%v secret not found", secretKey)}
+		}
+		return errGettingSecret
+	}
+
+	ref := fp.Spec.CredentialsSecretRef
+	accessKeyID := secret.Data[keyOrDefault(ref.AccessKeyIDKey, defaultAccessKeyIDKey)]
+	secretAccessKey := secret.Data[keyOrDefault(ref.SecretAccessKeyKey, defaultSecretAccessKeyKey)]
+	if len(accessKeyID) == 0 || len(secretAccessKey) == 0 {
+		return ErrInvalidCredentialsSecret{Message: fmt.Sprintf("%v secret is missing the access key id or secret access key", secretKey)}
+	}
+
+	cfg.CredentialsSource = secretKey.String()
+	cfg.AccessKeyID = string(accessKeyID)
+	cfg.SecretAccessKey = string(secretAccessKey)
+	cfg.SessionToken = string(secret.Data[keyOrDefault(ref.SessionTokenKey, defaultSessionTokenKey)])
+	return nil
+}
+
+// reconcileDeleteWithoutCredentials handles a CR being deleted while its credentials secret is missing or invalid,
+// like when its namespace is deleted and the secret goes away along with it. Without credentials the fargate-profile
+// cannot be deleted, so the finalizer is only released when nothing was created on AWS side or deletionPolicy is Retain.
+// Otherwise the CR is retried with backoff until the secret is restored or deletionPolicy is set to Retain.
+func (r *FargateProfileReconciler) reconcileDeleteWithoutCredentials(cr *v1alpha1.FargateProfile, errLoadingCredentials error) (ctrl.Result, error) {
+	crName := fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName())
+	fpName := cr.Status.FargateProfileName
+	if r.deletionPolicy(cr) == v1alpha1.Retain || (fpName == "" && cr.Status.PendingFargateProfileName == "") {
+		if errRemovingFinalizer := RemoveFinalizer(FargateProfileFinalizer, cr, r.Client); errRemovingFinalizer != nil {
+			return ctrl.Result{}, errRemovingFinalizer
+		}
+		r.reportRetainedFProfiles(cr, fpName)
+		forgetProfile(clusterLockOwner(cr))
+		r.releaseClusterLock(clusterLockOwner(cr))
+		return ctrl.Result{}, nil
+	}
+
+	errNoCredentials := ErrInvalidCredentialsSecret{Message: fmt.Sprintf("cannot delete fargate-profile %v without aws credentials, %v. "+
+		"Restore the secret, or set spec.deletionPolicy to %v to leave the profile on AWS side", fpName, errLoadingCredentials, v1alpha1.Retain)}
+	r.Log.Info(fmt.Sprintf("%s: %v", crName, errNoCredentials.Message))
+	r.Recorder.Event(cr, corev1.EventTypeWarning, errNoCredentials.Reason(), errNoCredentials.Error())
+	setConditionFromErr(cr, v1alpha1.Synced, errNoCredentials)
+	return ctrl.Result{}, errNoCredentials
+}
+
+func keyOrDefault(key, defaultKey string) string {
+	if key == "" {
+		return defaultKey
+	}
+	return key
+}
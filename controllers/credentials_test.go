@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func credentialsSecret(data map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "aws-credentials", Namespace: "default"},
+		Data:       map[string][]byte{},
+	}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+func TestLoadSecretCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     *v1alpha1.CredentialsSecretRef
+		secret  *corev1.Secret
+		want    AwsSessionConfig
+		wantErr bool
+	}{
+		{name: "no secret ref", want: AwsSessionConfig{Region: "us-east-1"}},
+		{name: "default keys", ref: &v1alpha1.CredentialsSecretRef{Name: "aws-credentials"},
+			secret: credentialsSecret(map[string]string{"AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_SESSION_TOKEN": "token"}),
+			want: AwsSessionConfig{Region: "us-east-1", CredentialsSource: "default/aws-credentials",
+				AccessKeyID: "id", SecretAccessKey: "secret", SessionToken: "token"}},
+		{name: "custom keys", ref: &v1alpha1.CredentialsSecretRef{Name: "aws-credentials", AccessKeyIDKey: "id", SecretAccessKeyKey: "key"},
+			secret: credentialsSecret(map[string]string{"id": "id", "key": "secret"}),
+			want:   AwsSessionConfig{Region: "us-east-1", CredentialsSource: "default/aws-credentials", AccessKeyID: "id", SecretAccessKey: "secret"}},
+		{name: "secret not found", ref: &v1alpha1.CredentialsSecretRef{Name: "aws-credentials"}, wantErr: true},
+		{name: "missing secret access key", ref: &v1alpha1.CredentialsSecretRef{Name: "aws-credentials"},
+			secret: credentialsSecret(map[string]string{"AWS_ACCESS_KEY_ID": "id"}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			if errAdding := clientgoscheme.AddToScheme(scheme); errAdding != nil {
+				t.Fatal(errAdding)
+			}
+			reader := fake.NewFakeClientWithScheme(scheme)
+			if tt.secret != nil {
+				reader = fake.NewFakeClientWithScheme(scheme, tt.secret)
+			}
+			fp := &v1alpha1.FargateProfile{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec:       v1alpha1.FargateProfileSpec{Region: "us-east-1", CredentialsSecretRef: tt.ref},
+			}
+
+			cfg := NewAwsSessionConfig(fp)
+			errLoading := loadSecretCredentials(reader, fp, &cfg)
+			if tt.wantErr {
+				if _, isInvalidSecret := errLoading.(ErrInvalidCredentialsSecret); !isInvalidSecret {
+					t.Fatalf("expected an ErrInvalidCredentialsSecret, got %v", errLoading)
+				}
+				return
+			}
+			if errLoading != nil {
+				t.Fatal(errLoading)
+			}
+			if cfg != tt.want {
+				t.Errorf("loadSecretCredentials() = %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileMissingCredentialsSecret(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.CredentialsSecretRef = &v1alpha1.CredentialsSecretRef{Name: "aws-credentials"}
+	})
+
+	result, cr := rt.reconcileResult()
+	if cr.Status.Phase != v1alpha1.Failed || len(rt.eks.profiles) != 0 {
+		t.Fatalf("expected the CR to fail without creating anything, got phase %v", cr.Status.Phase)
+	}
+	if result.RequeueAfter == 0 {
+		t.Fatal("expected the CR to be requeued until the secret shows up")
+	}
+
+	secret := credentialsSecret(map[string]string{"AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "secret"})
+	if errCreating := rt.client.Create(context.TODO(), secret); errCreating != nil {
+		t.Fatal(errCreating)
+	}
+	if cr = rt.reconcile(); cr.Status.Phase != v1alpha1.Creating {
+		t.Errorf("expected the secret to be picked up on the requeue, got phase %v", cr.Status.Phase)
+	}
+}
+
+func TestReconcileDeleteWithoutCredentialsSecret(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	secret := credentialsSecret(map[string]string{"AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "secret"})
+	if errCreating := rt.client.Create(context.TODO(), secret); errCreating != nil {
+		t.Fatal(errCreating)
+	}
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.CredentialsSecretRef = &v1alpha1.CredentialsSecretRef{Name: "aws-credentials"}
+	})
+	rt.createReady()
+	rt.events()
+
+	// deleting the namespace removes the secret along with the CR
+	if errDeleting := rt.client.Delete(context.TODO(), secret); errDeleting != nil {
+		t.Fatal(errDeleting)
+	}
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	result, cr := rt.reconcileResult()
+	if len(cr.GetFinalizers()) != 1 || result.RequeueAfter == 0 {
+		t.Fatalf("expected the finalizer to be kept and the CR requeued, got %v and %+v", cr.GetFinalizers(), result)
+	}
+	if !hasEvent(rt.events(), corev1.EventTypeWarning, ErrInvalidCredentialsSecret{}.Reason()) {
+		t.Error("expected a warning that the fargate-profile cannot be deleted")
+	}
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.DeletionPolicy = v1alpha1.Retain
+	})
+	if cr = rt.reconcile(); len(cr.GetFinalizers()) != 0 {
+		t.Fatalf("expected Retain to release the finalizer, got %v", cr.GetFinalizers())
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusActive {
+		t.Errorf("expected the fargate-profile to be left on AWS side, got %v", rt.eks.profiles["web"].Status)
+	}
+}
+
+func TestReconcileDeleteWithoutCredentialsSecretBeforeCreate(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.CredentialsSecretRef = &v1alpha1.CredentialsSecretRef{Name: "aws-credentials"}
+		cr.Finalizers = []string{FargateProfileFinalizer}
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+
+	// nothing was ever created on AWS side, so there is nothing to wait for
+	if cr := rt.reconcile(); len(cr.GetFinalizers()) != 0 {
+		t.Errorf("expected the finalizer to be released, got %v", cr.GetFinalizers())
+	}
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// tags eks, eksctl and the cloud provider put on worker nodes
+const (
+	eksClusterNameTag          = "eks:cluster-name"
+	eksctlClusterNameTag       = "alpha.eksctl.io/cluster-name"
+	kubernetesClusterTagPrefix = "kubernetes.io/cluster/"
+)
+
+// DetectDefaults looks up the region and eks cluster name of the node the controller runs on
+// using the EC2 instance metadata and the tags of the instance
+func DetectDefaults(ctx context.Context, retry RetryConfig) (string, string, error) {
+	identity, errGettingIdentity := imds.New(imds.Options{}).GetInstanceIdentityDocument(ctx, &imds.GetInstanceIdentityDocumentInput{})
+	if errGettingIdentity != nil {
+		return "", "", fmt.Errorf("ec2 instance metadata is not available: %w", errGettingIdentity)
+	}
+
+	awsCfg, errLoadingConfig := newAwsConfig(ctx, AwsSessionConfig{Region: identity.Region}, retry)
+	if errLoadingConfig != nil {
+		return identity.Region, "", errLoadingConfig
+	}
+	out, errDescribingTags := ec2.NewFromConfig(awsCfg).DescribeTags(ctx, &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("resource-id"),
+				Values: []string{identity.InstanceID},
+			},
+		},
+	})
+	if errDescribingTags != nil {
+		return identity.Region, "", errDescribingTags
+	}
+
+	return identity.Region, clusterNameFromTags(out.Tags), nil
+}
+
+func clusterNameFromTags(tags []types.TagDescription) string {
+	for _, tag := range tags {
+		key := aws.ToString(tag.Key)
+		switch {
+		case key == eksClusterNameTag, key == eksctlClusterNameTag:
+			return aws.ToString(tag.Value)
+		case strings.HasPrefix(key, kubernetesClusterTagPrefix):
+			return strings.TrimPrefix(key, kubernetesClusterTagPrefix)
+		}
+	}
+	return ""
+}
@@ -1,20 +1,92 @@
 package controllers
 
 import (
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/eks"
-	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
-func deleteFprofile(deleteIn *eks.DeleteFargateProfileInput, eksClient eksiface.EKSAPI) error {
+func deleteFprofile(ctx context.Context, deleteIn *eks.DeleteFargateProfileInput, eksClient EksAPI) error {
 
-	if _, errDeleting := eksClient.DeleteFargateProfile(deleteIn); errDeleting != nil {
+	if _, errDeleting := eksClient.DeleteFargateProfile(ctx, deleteIn); errDeleting != nil {
 		// remove finalizer if it does not exist on AWS side
-		if awsErr, ok := errDeleting.(awserr.Error); ok && awsErr.Code() == eks.ErrCodeResourceNotFoundException {
+		var notFound *types.ResourceNotFoundException
+		if errors.As(errDeleting, &notFound) {
 			return nil
 		}
-		return errDeleting
+		return typedAwsErr(errDeleting)
 	}
 
 	return nil
 }
+
+// deleteAndWait deletes the fargate-profile and keeps requeueing until AWS no longer knows about it.
+// EKS deletes one profile per cluster at a time, so releasing the finalizer while the profile is
+// still DELETING would make a CR recreated with the same name fail to create its profile.
+func (r *FargateProfileReconciler) deleteAndWait(ctx context.Context, cr *v1alpha1.FargateProfile, fp *types.FargateProfile, eksClient EksAPI) (ctrl.Result, error) {
+	crName := fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName())
+	fpName := aws.ToString(fp.FargateProfileName)
+	setAwsStatus(cr, fp)
+
+	switch fp.Status {
+	case types.FargateProfileStatusDeleting:
+		setCondition(cr, v1alpha1.ProfileActive, metav1.ConditionFalse, "Deleting", fmt.Sprintf("%v fargate-profile is being deleted", fpName))
+		r.Log.Info(fmt.Sprintf("%s: waiting for fargate-profile %v to be deleted", crName, fpName))
+		updateCrPhase(v1alpha1.Deleting, cr)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+
+	case types.FargateProfileStatusDeleteFailed:
+		errDeleteFailed := ErrFargateProfileDeleteFailed{Message: fmt.Sprintf("%v fargate-profile failed to delete: %v", fpName, fProfileHealthIssues(fp))}
+		r.Log.Info(fmt.Sprintf("%s: %v, retrying", crName, errDeleteFailed.Message))
+		r.Recorder.Event(cr, corev1.EventTypeWarning, errDeleteFailed.Reason(), errDeleteFailed.Error())
+		setConditionFromErr(cr, v1alpha1.ProfileActive, errDeleteFailed)
+		setConditionFromErr(cr, v1alpha1.Synced, errDeleteFailed)
+		// retry the deletion, the issues may have been fixed since
+		if r.acquireClusterLock(cr) {
+			if errDeletingFprofile := deleteFprofile(ctx, cr.WithDeleteIn(fpName), eksClient); errDeletingFprofile != nil {
+				r.Log.Error(errDeletingFprofile, "Failed to delete fargate-profile")
+				r.releaseClusterLock(clusterLockOwner(cr))
+			}
+		}
+		updateCrPhase(v1alpha1.Failed, cr)
+		return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+	}
+
+	if !r.acquireClusterLock(cr) {
+		return ctrl.Result{RequeueAfter: clusterLockRetryInterval}, nil
+	}
+	if errDeletingFprofile := deleteFprofile(ctx, cr.WithDeleteIn(fpName), eksClient); errDeletingFprofile != nil {
+		r.Log.Error(errDeletingFprofile, "Failed to delete fargate-profile")
+		r.releaseClusterLock(clusterLockOwner(cr))
+		return ctrl.Result{}, errDeletingFprofile
+	}
+	setCondition(cr, v1alpha1.ProfileActive, metav1.ConditionFalse, "Deleting", fmt.Sprintf("%v fargate-profile is being deleted", fpName))
+	updateCrPhase(v1alpha1.Deleting, cr)
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+}
+
+// fProfileHealthIssues formats the health issues AWS reports for a fargate-profile
+func fProfileHealthIssues(fp *types.FargateProfile) string {
+	if fp.Health == nil || len(fp.Health.Issues) == 0 {
+		return "no health issues reported"
+	}
+	var issues []string
+	for _, issue := range fp.Health.Issues {
+		msg := fmt.Sprintf("%v: %v", issue.Code, aws.ToString(issue.Message))
+		if len(issue.ResourceIds) > 0 {
+			msg += fmt.Sprintf(" (%v)", strings.Join(issue.ResourceIds, ", "))
+		}
+		issues = append(issues, msg)
+	}
+	return strings.Join(issues, "; ")
+}
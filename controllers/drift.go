@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// disappearedReason marks the Drifted condition of a CR whose fargate-profile was deleted outside of the controller
+const disappearedReason = "Disappeared"
+
+// fProfileDrift lists every field of the fargate-profile on AWS side that differs from the desired create input
+func fProfileDrift(desired *eks.CreateFargateProfileInput, current *types.FargateProfile) []v1alpha1.FieldDrift {
+	var drift []v1alpha1.FieldDrift
+	if aws.ToString(desired.PodExecutionRoleArn) != aws.ToString(current.PodExecutionRoleArn) {
+		drift = append(drift, v1alpha1.FieldDrift{
+			Field:    "podExecutionRoleArn",
+			Expected: aws.ToString(desired.PodExecutionRoleArn),
+			Actual:   aws.ToString(current.PodExecutionRoleArn),
+		})
+	}
+	if !v1alpha1.EqualStringSets(desired.Subnets, current.Subnets) {
+		drift = append(drift, v1alpha1.FieldDrift{
+			Field:    "subnets",
+			Expected: sortedJoin(desired.Subnets),
+			Actual:   sortedJoin(current.Subnets),
+		})
+	}
+	if desiredSelectors, currentSelectors := selectorKeys(desired.Selectors), selectorKeys(current.Selectors); !v1alpha1.EqualStringSets(desiredSelectors, currentSelectors) {
+		drift = append(drift, v1alpha1.FieldDrift{
+			Field:    "selectors",
+			Expected: sortedJoin(desiredSelectors),
+			Actual:   sortedJoin(currentSelectors),
+		})
+	}
+	if toSet, toRemove := tagsDiff(desired.Tags, current.Tags); len(toSet) > 0 || len(toRemove) > 0 {
+		drift = append(drift, v1alpha1.FieldDrift{
+			Field:    "tags",
+			Expected: tagsString(desired.Tags),
+			Actual:   tagsString(current.Tags),
+		})
+	}
+	return drift
+}
+
+func sortedJoin(values []string) string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "; ")
+}
+
+// tagsString renders tags as sorted key=value pairs, leaving out the tags AWS manages itself
+func tagsString(tags map[string]string) string {
+	var pairs []string
+	for key, value := range tags {
+		if !strings.HasPrefix(key, awsReservedTagPrefix) {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	return sortedJoin(pairs)
+}
+
+// driftPolicy returns what to do when the fargate-profile on AWS side was changed outside of the controller
+func driftPolicy(cr *v1alpha1.FargateProfile) v1alpha1.DriftPolicy {
+	if cr.Spec.DriftPolicy != "" {
+		return cr.Spec.DriftPolicy
+	}
+	return v1alpha1.Heal
+}
+
+// isOutOfBandDrift tells drift from a pending spec change. Once the spec generation was reconciled
+// to Ready, any difference with the fargate-profile on AWS side was made outside of the controller.
+func isOutOfBandDrift(cr *v1alpha1.FargateProfile) bool {
+	return cr.Status.ObservedGeneration != 0 && cr.Status.ObservedGeneration == cr.GetGeneration()
+}
+
+// reportDrift records the drift of the fargate-profile in status and emits an event when it is first seen
+func (r *FargateProfileReconciler) reportDrift(cr *v1alpha1.FargateProfile, fpName string, drift []v1alpha1.FieldDrift) {
+	cr.Status.Drift = drift
+	if len(drift) == 0 {
+		setCondition(cr, v1alpha1.Drifted, metav1.ConditionFalse, "NoDrift", fmt.Sprintf("%v fargate-profile matches spec", fpName))
+		return
+	}
+
+	var fields []string
+	for _, d := range drift {
+		fields = append(fields, d.Field)
+	}
+	reason, action := "DriftReported", "reporting only because driftPolicy is "+string(v1alpha1.Report)
+	if driftPolicy(cr) == v1alpha1.Heal {
+		reason, action = "Healing", "bringing it back to spec"
+	}
+	message := fmt.Sprintf("%v fargate-profile was changed outside of the controller (%v), %v", fpName, strings.Join(fields, ", "), action)
+
+	if previous := findCondition(cr, v1alpha1.Drifted); previous == nil || previous.Status != metav1.ConditionTrue {
+		r.Recorder.Event(cr, corev1.EventTypeWarning, "DriftDetected", message)
+	}
+	r.Log.Info(fmt.Sprintf("%s/%s: %v", cr.GetNamespace(), cr.GetName(), message))
+	setCondition(cr, v1alpha1.Drifted, metav1.ConditionTrue, reason, message)
+}
+
+// fProfileDisappeared tells whether a fargate-profile that cannot be found was deleted outside of the controller,
+// either right after it was Ready or earlier with the CR still waiting for a human to look at it
+func fProfileDisappeared(cr *v1alpha1.FargateProfile) bool {
+	if cr.Status.Phase == v1alpha1.Ready {
+		return true
+	}
+	cond := findCondition(cr, v1alpha1.Drifted)
+	return cond != nil && cond.Status == metav1.ConditionTrue && cond.Reason == disappearedReason
+}
+
+// reconcileDisappeared applies the drift policy to a Ready fargate-profile that was deleted outside of the controller.
+// Heal recreates it, Report marks the CR Failed until its spec changes, e.g. to driftPolicy Heal.
+// It returns recreate=true when the reconcile should carry on and create the profile again.
+func (r *FargateProfileReconciler) reconcileDisappeared(cr *v1alpha1.FargateProfile, fpName string) (ctrl.Result, bool, error) {
+	message := fmt.Sprintf("%v fargate-profile was deleted outside of the controller", fpName)
+	if cr.Status.Phase == v1alpha1.Ready {
+		r.Log.Info(fmt.Sprintf("%s/%s: %v", cr.GetNamespace(), cr.GetName(), message))
+		r.Recorder.Event(cr, corev1.EventTypeWarning, disappearedReason, message)
+		profilesDisappeared.WithLabelValues(cr.Spec.ClusterName).Inc()
+	}
+	cr.Status.Drift = []v1alpha1.FieldDrift{{Field: "fargateProfile", Expected: fpName}}
+
+	if driftPolicy(cr) == v1alpha1.Report && isOutOfBandDrift(cr) {
+		setCondition(cr, v1alpha1.Drifted, metav1.ConditionTrue, disappearedReason,
+			fmt.Sprintf("%v, not recreating it because driftPolicy is %v", message, v1alpha1.Report))
+		setCondition(cr, v1alpha1.Synced, metav1.ConditionFalse, disappearedReason, message)
+		setCondition(cr, v1alpha1.ProfileActive, metav1.ConditionFalse, disappearedReason, message)
+		updateCrPhase(v1alpha1.Failed, cr)
+		return ctrl.Result{}, false, nil
+	}
+
+	setCondition(cr, v1alpha1.Drifted, metav1.ConditionTrue, disappearedReason, message+", recreating it")
+	return ctrl.Result{}, true, nil
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFProfileDrift(t *testing.T) {
+	desired := func() *eks.CreateFargateProfileInput {
+		return &eks.CreateFargateProfileInput{
+			PodExecutionRoleArn: aws.String("arn:aws:iam::123456789012:role/fargate"),
+			Subnets:             []string{"subnet-a", "subnet-b"},
+			Selectors: []types.FargateProfileSelector{
+				{Namespace: aws.String("web"), Labels: map[string]string{"app": "web", "tier": "frontend"}},
+			},
+			Tags: map[string]string{"team": "web"},
+		}
+	}
+	current := func() *types.FargateProfile {
+		return &types.FargateProfile{
+			PodExecutionRoleArn: aws.String("arn:aws:iam::123456789012:role/fargate"),
+			Subnets:             []string{"subnet-b", "subnet-a"},
+			Selectors: []types.FargateProfileSelector{
+				{Namespace: aws.String("web"), Labels: map[string]string{"tier": "frontend", "app": "web"}},
+			},
+			Tags: map[string]string{"team": "web", "aws:cloudformation:stack-name": "stack"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(fp *types.FargateProfile)
+		want   []v1alpha1.FieldDrift
+	}{
+		{name: "no drift", mutate: func(fp *types.FargateProfile) {}},
+		{
+			name: "role",
+			mutate: func(fp *types.FargateProfile) {
+				fp.PodExecutionRoleArn = aws.String("arn:aws:iam::123456789012:role/other")
+			},
+			want: []v1alpha1.FieldDrift{{
+				Field:    "podExecutionRoleArn",
+				Expected: "arn:aws:iam::123456789012:role/fargate",
+				Actual:   "arn:aws:iam::123456789012:role/other",
+			}},
+		},
+		{
+			name:   "subnets",
+			mutate: func(fp *types.FargateProfile) { fp.Subnets = []string{"subnet-c", "subnet-a"} },
+			want:   []v1alpha1.FieldDrift{{Field: "subnets", Expected: "subnet-a; subnet-b", Actual: "subnet-a; subnet-c"}},
+		},
+		{
+			name:   "selectors",
+			mutate: func(fp *types.FargateProfile) { fp.Selectors[0].Labels = nil },
+			want:   []v1alpha1.FieldDrift{{Field: "selectors", Expected: "web|app=web,tier=frontend", Actual: "web|"}},
+		},
+		{
+			name:   "tags",
+			mutate: func(fp *types.FargateProfile) { fp.Tags["owner"] = "me" },
+			want:   []v1alpha1.FieldDrift{{Field: "tags", Expected: "team=web", Actual: "owner=me; team=web"}},
+		},
+		{
+			name: "several fields",
+			mutate: func(fp *types.FargateProfile) {
+				fp.Subnets = []string{"subnet-a"}
+				fp.Tags = nil
+			},
+			want: []v1alpha1.FieldDrift{
+				{Field: "subnets", Expected: "subnet-a; subnet-b", Actual: "subnet-a"},
+				{Field: "tags", Expected: "team=web"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := current()
+			tt.mutate(fp)
+			if got := fProfileDrift(desired(), fp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fProfileDrift() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileAuditsReadyProfile(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+
+	// nothing changes the CR, only the requeue of the audit brings the reconciler back
+	result, _ := rt.reconcileResult()
+	if result.RequeueAfter != testAuditInterval {
+		t.Fatalf("expected a Ready CR to be audited after %v, got %+v", testAuditInterval, result)
+	}
+
+	rt.eks.profiles["web"].Subnets = []string{"subnet-0123abcd"}
+	cr := rt.reconcile()
+	if cond := findCondition(cr, v1alpha1.Drifted); cond == nil || cond.Status != metav1.ConditionTrue {
+		t.Fatalf("expected the Drifted condition, got %+v", cond)
+	}
+	if cr.Status.Phase != v1alpha1.Replacing || rt.eks.profiles["web"].Status != types.FargateProfileStatusDeleting {
+		t.Fatalf("expected the drifted fargate-profile to be replaced, got phase %v and %v", cr.Status.Phase, rt.eks.profiles["web"].Status)
+	}
+
+	rt.eks.settle("web")
+	rt.reconcile()
+	rt.eks.settle("web")
+	cr = rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Ready || len(rt.eks.profiles["web"].Subnets) != 2 {
+		t.Errorf("expected the fargate-profile to be healed, got phase %v and subnets %v", cr.Status.Phase, rt.eks.profiles["web"].Subnets)
+	}
+}
+
+func TestReconcileHealsBlueGreen(t *testing.T) {
+	rt := newReconcileTest(t, v1alpha1.BlueGreen)
+	rt.createReady()
+
+	// healing does not bump the generation, the rollout still gets a name of its own
+	rt.eks.profiles["web"].Subnets = []string{"subnet-0123abcd"}
+	cr := rt.reconcile()
+	if cr.Status.PendingFargateProfileName != "web-1" || rt.eks.profiles["web-1"] == nil {
+		t.Fatalf("expected web-1 to be rolled out, got pending %q", cr.Status.PendingFargateProfileName)
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusActive {
+		t.Fatalf("expected web to keep serving pods while healing, got %v", rt.eks.profiles["web"].Status)
+	}
+
+	rt.eks.settle("web-1")
+	rt.reconcile()
+	rt.eks.settle("web")
+	cr = rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Ready || cr.Status.FargateProfileName != "web-1" {
+		t.Fatalf("expected web-1 to replace web, got phase %v and %q", cr.Status.Phase, cr.Status.FargateProfileName)
+	}
+
+	// a second heal must not reuse the name of the profile it replaces
+	rt.eks.profiles["web-1"].Subnets = []string{"subnet-0123abcd"}
+	if cr = rt.reconcile(); cr.Status.PendingFargateProfileName != "web-2" || rt.eks.profiles["web-2"] == nil {
+		t.Errorf("expected web-2 to be rolled out, got pending %q", cr.Status.PendingFargateProfileName)
+	}
+}
+
+func TestReconcileReportsDrift(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.DriftPolicy = v1alpha1.Report
+	})
+	rt.createReady()
+
+	rt.eks.profiles["web"].Selectors = []types.FargateProfileSelector{{Namespace: aws.String("other")}}
+	result, cr := rt.reconcileResult()
+	if result.RequeueAfter != testAuditInterval {
+		t.Errorf("expected the drifted CR to keep being audited, got %+v", result)
+	}
+	if len(cr.Status.Drift) != 1 || cr.Status.Drift[0].Field != "selectors" {
+		t.Errorf("expected the selectors drift in status, got %+v", cr.Status.Drift)
+	}
+	if cond := findCondition(cr, v1alpha1.Synced); cond == nil || cond.Status != metav1.ConditionFalse {
+		t.Errorf("expected the CR not to be Synced, got %+v", cond)
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusActive {
+		t.Errorf("expected the drifted fargate-profile to be left alone, got %v", rt.eks.profiles["web"].Status)
+	}
+}
+
+func TestReconcileDisappearedHeal(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+	rt.events()
+	disappeared := testutil.ToFloat64(profilesDisappeared.WithLabelValues("prod"))
+
+	delete(rt.eks.profiles, "web")
+	cr := rt.reconcile()
+	if !hasEvent(rt.events(), corev1.EventTypeWarning, disappearedReason) {
+		t.Error("expected a Disappeared event")
+	}
+	if got := testutil.ToFloat64(profilesDisappeared.WithLabelValues("prod")); got != disappeared+1 {
+		t.Errorf("expected profiles_disappeared_total to go up by one, got %v after %v", got, disappeared)
+	}
+	if cond := findCondition(cr, v1alpha1.Drifted); cond == nil || cond.Reason != disappearedReason {
+		t.Errorf("expected the Drifted condition to report the disappearance, got %+v", cond)
+	}
+	if cr.Status.Phase != v1alpha1.Creating || rt.eks.profiles["web"] == nil {
+		t.Fatalf("expected the fargate-profile to be recreated, got phase %v", cr.Status.Phase)
+	}
+
+	rt.eks.settle("web")
+	if cr = rt.reconcile(); cr.Status.Phase != v1alpha1.Ready {
+		t.Errorf("expected phase %v, got %v", v1alpha1.Ready, cr.Status.Phase)
+	}
+	if got := testutil.ToFloat64(profilesDisappeared.WithLabelValues("prod")); got != disappeared+1 {
+		t.Errorf("expected the recreated fargate-profile not to be counted again, got %v", got)
+	}
+}
+
+func TestReconcileDisappearedReport(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.DriftPolicy = v1alpha1.Report
+	})
+	rt.createReady()
+	rt.events()
+
+	delete(rt.eks.profiles, "web")
+	cr := rt.reconcile()
+	if !hasEvent(rt.events(), corev1.EventTypeWarning, disappearedReason) {
+		t.Error("expected a Disappeared event")
+	}
+	if cr.Status.Phase != v1alpha1.Failed || rt.eks.profiles["web"] != nil {
+		t.Fatalf("expected the CR to fail without recreating the fargate-profile, got phase %v", cr.Status.Phase)
+	}
+
+	// the CR waits for a human, later audits do not recreate the profile either
+	if cr = rt.reconcile(); cr.Status.Phase != v1alpha1.Failed || rt.eks.profiles["web"] != nil {
+		t.Fatalf("expected the CR to stay Failed, got phase %v", cr.Status.Phase)
+	}
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.DriftPolicy = v1alpha1.Heal
+		cr.Generation++
+	})
+	if cr = rt.reconcile(); cr.Status.Phase != v1alpha1.Creating || rt.eks.profiles["web"] == nil {
+		t.Errorf("expected switching to Heal to recreate the fargate-profile, got phase %v", cr.Status.Phase)
+	}
+}
@@ -8,6 +8,10 @@ func (e ErrEksClusterNotFound) Error() string {
 	return e.Message
 }
 
+func (e ErrEksClusterNotFound) Reason() string {
+	return "EksClusterNotFound"
+}
+
 type ErrEksClusterNotActive struct {
 	Message string
 }
@@ -16,6 +20,10 @@ func (e ErrEksClusterNotActive) Error() string {
 	return e.Message
 }
 
+func (e ErrEksClusterNotActive) Reason() string {
+	return "EksClusterNotActive"
+}
+
 type ErrInvalidSubnet struct {
 	Message string
 }
@@ -24,6 +32,10 @@ func (e ErrInvalidSubnet) Error() string {
 	return e.Message
 }
 
+func (e ErrInvalidSubnet) Reason() string {
+	return "InvalidSubnet"
+}
+
 type ErrPodExecutionRoleArnNotFound struct {
 	Message string
 }
@@ -31,3 +43,163 @@ type ErrPodExecutionRoleArnNotFound struct {
 func (e ErrPodExecutionRoleArnNotFound) Error() string {
 	return e.Message
 }
+
+func (e ErrPodExecutionRoleArnNotFound) Reason() string {
+	return "PodExecutionRoleArnNotFound"
+}
+
+type ErrInvalidCredentialsSecret struct {
+	Message string
+}
+
+func (e ErrInvalidCredentialsSecret) Error() string {
+	return e.Message
+}
+
+func (e ErrInvalidCredentialsSecret) Reason() string {
+	return "InvalidCredentialsSecret"
+}
+
+type ErrFargateProfileNotAdoptable struct {
+	Message string
+}
+
+func (e ErrFargateProfileNotAdoptable) Error() string {
+	return e.Message
+}
+
+func (e ErrFargateProfileNotAdoptable) Reason() string {
+	return "FargateProfileNotAdoptable"
+}
+
+type ErrFargateProfileNotOwned struct {
+	Message string
+}
+
+func (e ErrFargateProfileNotOwned) Error() string {
+	return e.Message
+}
+
+func (e ErrFargateProfileNotOwned) Reason() string {
+	return "FargateProfileNotOwned"
+}
+
+type ErrInvalidProfileName struct {
+	Message string
+}
+
+func (e ErrInvalidProfileName) Error() string {
+	return e.Message
+}
+
+func (e ErrInvalidProfileName) Reason() string {
+	return "InvalidProfileName"
+}
+
+type ErrFargateProfileDeleteFailed struct {
+	Message string
+}
+
+func (e ErrFargateProfileDeleteFailed) Error() string {
+	return e.Message
+}
+
+func (e ErrFargateProfileDeleteFailed) Reason() string {
+	return "DeleteFailed"
+}
+
+type ErrMissingRequiredField struct {
+	Message string
+}
+
+func (e ErrMissingRequiredField) Error() string {
+	return e.Message
+}
+
+func (e ErrMissingRequiredField) Reason() string {
+	return "MissingRequiredField"
+}
+
+type ErrProtectedTagOverride struct {
+	Message string
+}
+
+func (e ErrProtectedTagOverride) Error() string {
+	return e.Message
+}
+
+func (e ErrProtectedTagOverride) Reason() string {
+	return "ProtectedTagOverride"
+}
+
+// ErrResourceInUse means EKS is busy with another operation on the profile or cluster
+type ErrResourceInUse struct {
+	Message string
+	Err     error
+}
+
+func (e ErrResourceInUse) Error() string {
+	return e.Message
+}
+
+func (e ErrResourceInUse) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrResourceInUse) Reason() string {
+	return "ResourceInUse"
+}
+
+// ErrLimitExceeded means an AWS quota was hit, like the 10 fargate-profiles per cluster
+type ErrLimitExceeded struct {
+	Message string
+	Err     error
+}
+
+func (e ErrLimitExceeded) Error() string {
+	return e.Message
+}
+
+func (e ErrLimitExceeded) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrLimitExceeded) Reason() string {
+	return "LimitExceeded"
+}
+
+// ErrInvalidParameter means AWS rejected the request built from the spec
+type ErrInvalidParameter struct {
+	Message string
+	Err     error
+}
+
+func (e ErrInvalidParameter) Error() string {
+	return e.Message
+}
+
+func (e ErrInvalidParameter) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrInvalidParameter) Reason() string {
+	return "InvalidParameter"
+}
+
+// ErrAccessDenied means the credentials used for the profile lack an IAM permission
+type ErrAccessDenied struct {
+	Message string
+	Err     error
+}
+
+func (e ErrAccessDenied) Error() string {
+	return e.Message
+}
+
+func (e ErrAccessDenied) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrAccessDenied) Reason() string {
+	return "AccessDenied"
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// missingClusterEks does not know the cluster of the CR
+type missingClusterEks struct{ *fakeEks }
+
+func (missingClusterEks) DescribeCluster(context.Context, *eks.DescribeClusterInput, ...func(*eks.Options)) (*eks.DescribeClusterOutput, error) {
+	return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+}
+
+// creatingClusterEks knows the cluster of the CR while it is still being created
+type creatingClusterEks struct{ *fakeEks }
+
+func (f creatingClusterEks) DescribeCluster(ctx context.Context, in *eks.DescribeClusterInput, optFns ...func(*eks.Options)) (*eks.DescribeClusterOutput, error) {
+	out, err := f.fakeEks.DescribeCluster(ctx, in, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	out.Cluster.Status = types.ClusterStatusCreating
+	return out, nil
+}
+
+// missingRoleIam does not know the pod execution role
+type missingRoleIam struct{}
+
+func (missingRoleIam) GetRole(context.Context, *iam.GetRoleInput, ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	return nil, &iamtypes.NoSuchEntityException{Message: aws.String("not found")}
+}
+
+// otherVpcEc2 puts every subnet into a VPC the cluster does not run in
+type otherVpcEc2 struct{ fakeEc2 }
+
+func (otherVpcEc2) DescribeSubnets(_ context.Context, in *ec2.DescribeSubnetsInput, _ ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	var subnets []ec2types.Subnet
+	for _, filter := range in.Filters {
+		for _, subnetID := range filter.Values {
+			subnets = append(subnets, ec2types.Subnet{SubnetId: aws.String(subnetID), VpcId: aws.String("vpc-other")})
+		}
+	}
+	return &ec2.DescribeSubnetsOutput{Subnets: subnets}, nil
+}
+
+func TestReconcileLifecycleEvents(t *testing.T) {
+	rt := newReconcileTest(t, "")
+
+	rt.reconcile()
+	if !hasEvent(rt.events(), corev1.EventTypeNormal, "Creating") {
+		t.Error("expected a Creating event")
+	}
+	rt.eks.settle("web")
+	rt.reconcile()
+	if !hasEvent(rt.events(), corev1.EventTypeNormal, "Active") {
+		t.Error("expected an Active event")
+	}
+
+	// nothing changed, the audit does not repeat the events
+	rt.reconcile()
+	if events := rt.events(); len(events) != 0 {
+		t.Errorf("expected no events for a Ready CR, got %v", events)
+	}
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	rt.reconcile()
+	if !hasEvent(rt.events(), corev1.EventTypeNormal, "Deleting") {
+		t.Error("expected a Deleting event")
+	}
+	rt.eks.settle("web")
+	rt.reconcile()
+	if !hasEvent(rt.events(), corev1.EventTypeNormal, "Deleted") {
+		t.Error("expected a Deleted event")
+	}
+}
+
+func TestReconcilePreFlightEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients func(rt *reconcileTest) AwsClients
+		reason  string
+	}{
+		{
+			name: "cluster not found",
+			clients: func(rt *reconcileTest) AwsClients {
+				return AwsClients{Eks: missingClusterEks{rt.eks}, Ec2: fakeEc2{}, Iam: fakeIam{}}
+			},
+			reason: ErrEksClusterNotFound{}.Reason(),
+		},
+		{
+			name: "cluster not active",
+			clients: func(rt *reconcileTest) AwsClients {
+				return AwsClients{Eks: creatingClusterEks{rt.eks}, Ec2: fakeEc2{}, Iam: fakeIam{}}
+			},
+			reason: ErrEksClusterNotActive{}.Reason(),
+		},
+		{
+			name: "role not found",
+			clients: func(rt *reconcileTest) AwsClients {
+				return AwsClients{Eks: rt.eks, Ec2: fakeEc2{}, Iam: missingRoleIam{}}
+			},
+			reason: ErrPodExecutionRoleArnNotFound{}.Reason(),
+		},
+		{
+			name: "subnets outside the cluster vpc",
+			clients: func(rt *reconcileTest) AwsClients {
+				return AwsClients{Eks: rt.eks, Ec2: otherVpcEc2{}, Iam: fakeIam{}}
+			},
+			reason: ErrInvalidSubnet{}.Reason(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReconcileTest(t, "")
+			rt.reconciler.AwsClients = fakeAwsClientFactory{clients: tt.clients(rt)}
+
+			cr := rt.reconcile()
+			if !hasEvent(rt.events(), corev1.EventTypeWarning, tt.reason) {
+				t.Errorf("expected a %v event", tt.reason)
+			}
+			if cond := findCondition(cr, v1alpha1.Synced); cond == nil || cond.Reason != tt.reason {
+				t.Errorf("expected the Synced condition with reason %v, got %+v", tt.reason, cond)
+			}
+			if len(rt.eks.profiles) != 0 {
+				t.Errorf("expected no fargate-profile to be created, got %v", rt.eks.profiles)
+			}
+		})
+	}
+}
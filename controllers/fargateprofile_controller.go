@@ -19,16 +19,22 @@ package controllers
 import (
 	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -38,62 +44,229 @@ import (
 // FargateProfileReconciler reconciles a FargateProfile object
 type FargateProfileReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log        logr.Logger
+	Scheme     *runtime.Scheme
+	Recorder   record.EventRecorder
+	AwsClients AwsClientFactory
+	Backoff    *RequeueBackoff
+	// APIReader reads credentials Secrets straight from the API server, defaults to the manager's
+	APIReader client.Reader
+	// ControllerID tells controller instances apart in the ownership tags of the fargate-profiles
+	ControllerID string
+	// ClusterLocks serializes creates and deletes per eks cluster, no serialization when nil
+	ClusterLocks *ClusterLocks
+	// ProfileNameTemplate names the fargate-profiles of CRs without spec.profileName, the CR name is used when nil
+	ProfileNameTemplate *template.Template
+	// DefaultDeletionPolicy applies to CRs without spec.deletionPolicy, Delete when empty
+	DefaultDeletionPolicy agillappsv1alpha1.DeletionPolicy
+	// DefaultTags are merged into the tags of every fargate-profile, nil when there are none
+	DefaultTags *agillappsv1alpha1.DefaultTags
+	// DefaultRegion and DefaultClusterName fill in FargateProfiles that do not specify their own,
+	// like the mutating webhook does for installs that have webhooks enabled
+	DefaultRegion      string
+	DefaultClusterName string
+	// ReconcileTimeout bounds how long the AWS calls of a single reconcile may take, no limit when zero
+	ReconcileTimeout time.Duration
+	// AuditInterval is how often a settled fargate-profile is compared with AWS to catch out-of-band changes,
+	// never when zero. Resyncs of the informer do not reach the reconciler, see SetupWithManager.
+	AuditInterval time.Duration
+
+	// ctx is cancelled when the manager stops so in-flight AWS calls are abandoned
+	ctx context.Context
 }
 
 // +kubebuilder:rbac:groups=agill.apps.eks-fargate-controller,resources=fargateprofiles,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=agill.apps.eks-fargate-controller,resources=fargateprofiles/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
 
-func (r *FargateProfileReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	_ = context.Background()
+func (r *FargateProfileReconciler) Reconcile(req ctrl.Request) (result ctrl.Result, err error) {
 	_ = r.Log.WithValues("fargateprofile", req.NamespacedName)
+	ctx, cancel := r.reconcileContext()
+	defer cancel()
 
 	cr := &agillappsv1alpha1.FargateProfile{}
-	if err := r.Client.Get(context.TODO(), req.NamespacedName, cr); err != nil {
+	if err := r.Client.Get(ctx, req.NamespacedName, cr); err != nil {
 		if errors.IsNotFound(err) {
+			forgetProfile(req.NamespacedName)
+			r.releaseClusterLock(req.NamespacedName)
 			// do not requeue
 			return ctrl.Result{}, nil
 		}
 		r.Log.Error(err, fmt.Sprintf("Failed to get CR from %v request", req.Namespace))
 		return ctrl.Result{}, err
 	}
-	eksClient := NewEksClient(cr.Spec.Region)
-	ec2Client := NewEc2Client(cr.Spec.Region)
-	iamClient := NewIamClient(cr.Spec.Region)
+
+	// phase and conditions are set along the way, this is the only place the status gets written
+	originalStatus := cr.Status.DeepCopy()
+	defer func() {
+		if cr.GetDeletionTimestamp() == nil {
+			recordProfilePhase(req.NamespacedName, cr)
+		}
+		if equality.Semantic.DeepEqual(originalStatus, &cr.Status) {
+			return
+		}
+		if errUpdatingStatus := updateCrStatus(r.Client, cr); errUpdatingStatus != nil && err == nil {
+			err = errUpdatingStatus
+		}
+	}()
+
+	// the sdk only retries a bounded number of times, back off here instead of blocking the worker
+	defer func() {
+		if err == nil {
+			r.Backoff.Forget(req.NamespacedName)
+			return
+		}
+		if awsResult, handled := r.handleAwsErr(cr, err); handled {
+			result, err = awsResult, nil
+			return
+		}
+		// nothing watches the credentials secret, keep checking back until it is created or fixed
+		if _, isInvalidSecret := err.(ErrInvalidCredentialsSecret); isInvalidSecret {
+			result = ctrl.Result{RequeueAfter: r.Backoff.Next(req.NamespacedName)}
+			r.Log.Info(fmt.Sprintf("%v: invalid credentials secret, requeueing after %v: %v", req.NamespacedName, result.RequeueAfter, err))
+			err = nil
+			return
+		}
+		if isRetryableAwsErr(err) {
+			result = ctrl.Result{RequeueAfter: r.Backoff.Next(req.NamespacedName)}
+			r.Log.Info(fmt.Sprintf("%v: retryable aws error, requeueing after %v: %v", req.NamespacedName, result.RequeueAfter, err))
+			err = nil
+		}
+	}()
+
+	if r.applySpecDefaults(cr) {
+		if errUpdatingCr := r.Client.Update(ctx, cr); errUpdatingCr != nil {
+			r.Log.Error(errUpdatingCr, fmt.Sprintf("%v: Failed to set the default region and cluster name", req.NamespacedName))
+			return ctrl.Result{}, errUpdatingCr
+		}
+	}
+	if errMissingField := validateRequiredFields(cr); errMissingField != nil {
+		// nothing can have been created on AWS side without a region and cluster name
+		if cr.GetDeletionTimestamp() != nil {
+			return ctrl.Result{}, RemoveFinalizer(FargateProfileFinalizer, cr, r.Client)
+		}
+		r.Log.Info(fmt.Sprintf("%s: %v", req.NamespacedName.String(), errMissingField.Error()))
+		r.Recorder.Event(cr, corev1.EventTypeWarning, ErrMissingRequiredField{}.Reason(), errMissingField.Error())
+		setConditionFromErr(cr, agillappsv1alpha1.Synced, errMissingField)
+		updateCrPhase(agillappsv1alpha1.Failed, cr)
+		return ctrl.Result{}, nil
+	}
+
+	awsCfg := NewAwsSessionConfig(cr)
+	if errLoadingCredentials := loadSecretCredentials(r.APIReader, cr, &awsCfg); errLoadingCredentials != nil {
+		r.Log.Error(errLoadingCredentials, fmt.Sprintf("%v: Failed to load aws credentials from secret", req.NamespacedName))
+		if _, isInvalidSecret := errLoadingCredentials.(ErrInvalidCredentialsSecret); isInvalidSecret {
+			if cr.GetDeletionTimestamp() != nil {
+				return r.reconcileDeleteWithoutCredentials(cr, errLoadingCredentials)
+			}
+			r.Recorder.Event(cr, corev1.EventTypeWarning, ErrInvalidCredentialsSecret{}.Reason(), errLoadingCredentials.Error())
+			setConditionFromErr(cr, agillappsv1alpha1.Synced, errLoadingCredentials)
+			cr.Status.Phase = agillappsv1alpha1.Failed
+		}
+		return ctrl.Result{}, errLoadingCredentials
+	}
+	awsClients, errCreatingClients := r.AwsClients.ClientsFor(ctx, awsCfg)
+	if errCreatingClients != nil {
+		r.Log.Error(errCreatingClients, fmt.Sprintf("%v: Failed to create aws clients", req.NamespacedName))
+		return ctrl.Result{}, errCreatingClients
+	}
+	eksClient, ec2Client, iamClient := awsClients.Eks, awsClients.Ec2, awsClients.Iam
+
+	fpName, errNamingFp := r.fargateProfileName(cr)
+	if errNamingFp != nil {
+		r.Log.Error(errNamingFp, fmt.Sprintf("%v: Failed to resolve the fargate-profile name", req.NamespacedName))
+		r.Recorder.Event(cr, corev1.EventTypeWarning, ErrInvalidProfileName{}.Reason(), errNamingFp.Error())
+		setConditionFromErr(cr, agillappsv1alpha1.Synced, errNamingFp)
+		updateCrPhase(agillappsv1alpha1.Failed, cr)
+		return ctrl.Result{}, nil
+	}
 
 	// add finalizers
 	if errAddingFinalizer := AddFinalizer(FargateProfileFinalizer, cr, r.Client); errAddingFinalizer != nil {
 		r.Log.Error(errAddingFinalizer, fmt.Sprintf("Failed to add finalizer to %s", req.NamespacedName.String()))
 		return ctrl.Result{}, errAddingFinalizer
 	}
+	claimLegacyFProfileName(cr)
 
 	// handle delete
 	if cr.GetDeletionTimestamp() != nil {
 
-		if errMarkingFpDeleting := updateCrPhase(agillappsv1alpha1.Deleting, r.Client, cr); errMarkingFpDeleting != nil {
-			return ctrl.Result{}, errMarkingFpDeleting
+		if r.deletionPolicy(cr) == agillappsv1alpha1.Retain {
+			if errRemovingFinalizer := RemoveFinalizer(FargateProfileFinalizer, cr, r.Client); errRemovingFinalizer != nil {
+				return ctrl.Result{}, errRemovingFinalizer
+			}
+			r.reportRetainedFProfiles(cr, fpName)
+			forgetProfile(req.NamespacedName)
+			r.releaseClusterLock(req.NamespacedName)
+			return ctrl.Result{}, nil
 		}
 
-		if errDeletingFprofile := deleteFprofile(cr.WithDeleteIn(), eksClient); errDeletingFprofile != nil {
-			r.Log.Error(errDeletingFprofile, "Failed to delete fargate-profile")
-			return ctrl.Result{}, errDeletingFprofile
+		if cr.Status.Phase != agillappsv1alpha1.Deleting {
+			r.Recorder.Event(cr, corev1.EventTypeNormal, "Deleting", fmt.Sprintf("Deleting fargate-profile %v", fpName))
+		}
+		setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionFalse, "Deleting", "the CR is being deleted")
+		updateCrPhase(agillappsv1alpha1.Deleting, cr)
+
+		if result, cleanedUp, errCleaningUp := r.cleanUpPendingFProfile(ctx, cr, eksClient); !cleanedUp {
+			return result, errCleaningUp
+		}
+		if result, deleted, errWaiting := r.waitForReplacedFProfile(ctx, cr, eksClient); !deleted {
+			return result, errWaiting
+		}
+
+		// only delete profiles this CR created or adopted
+		fpToDelete, fpToDeleteExists, errDescribingFp := fProfileExists(ctx, cr.Spec.ClusterName, fpName, eksClient)
+		if errDescribingFp != nil {
+			r.Log.Error(errDescribingFp, "Failed to describe fargate-profile before deleting it")
+			return ctrl.Result{}, errDescribingFp
+		}
+		if fpToDeleteExists && isManagedFProfile(cr, fpToDelete, r.ControllerID) {
+			return r.deleteAndWait(ctx, cr, fpToDelete, eksClient)
 		}
 		if errRemovingFinalizer := RemoveFinalizer(FargateProfileFinalizer, cr, r.Client); errRemovingFinalizer != nil {
 			return ctrl.Result{}, errRemovingFinalizer
 		}
-		r.Log.Info(fmt.Sprintf("%s: Successfully deleted fargate-profile", req.NamespacedName.String()))
+		if fpToDeleteExists {
+			r.Log.Info(fmt.Sprintf("%s: fargate-profile is not managed by this CR, leaving it on AWS side", req.NamespacedName.String()))
+			r.Recorder.Event(cr, corev1.EventTypeWarning, ErrFargateProfileNotOwned{}.Reason(), fmt.Sprintf("fargate-profile %v is not managed by this CR, leaving it on AWS side", fpName))
+		} else if cr.Status.FargateProfileName != "" {
+			r.Log.Info(fmt.Sprintf("%s: Successfully deleted fargate-profile", req.NamespacedName.String()))
+			r.Recorder.Event(cr, corev1.EventTypeNormal, "Deleted", fmt.Sprintf("Deleted fargate-profile %v", fpName))
+		}
+		observeDeleteDuration(cr)
+		forgetProfile(req.NamespacedName)
+		r.releaseClusterLock(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	if overrides := cr.ProtectedTagOverrides(r.DefaultTags); len(overrides) > 0 {
+		errProtectedTags := ErrProtectedTagOverride{Message: fmt.Sprintf("spec.tags cannot override the protected tags %v", strings.Join(overrides, ", "))}
+		r.Log.Info(fmt.Sprintf("%s: %v", req.NamespacedName.String(), errProtectedTags.Message))
+		r.Recorder.Event(cr, corev1.EventTypeWarning, errProtectedTags.Reason(), errProtectedTags.Error())
+		setConditionFromErr(cr, agillappsv1alpha1.Synced, errProtectedTags)
+		updateCrPhase(agillappsv1alpha1.Failed, cr)
 		return ctrl.Result{}, nil
 	}
 
 	// run some checks before attempting to create anything
-	if errCheckingPreReqs := runPreFlightChecks(eksClient, ec2Client, iamClient, cr); errCheckingPreReqs != nil {
+	if errCheckingPreReqs := runPreFlightChecks(ctx, eksClient, ec2Client, iamClient, cr); errCheckingPreReqs != nil {
+		if isHandledAwsErr(errCheckingPreReqs) {
+			return ctrl.Result{}, errCheckingPreReqs
+		}
+		setConditionFromErr(cr, agillappsv1alpha1.Synced, errCheckingPreReqs)
+		if reason, ok := errReason(errCheckingPreReqs); ok {
+			r.Recorder.Event(cr, corev1.EventTypeWarning, reason, errCheckingPreReqs.Error())
+		} else {
+			r.Recorder.Event(cr, corev1.EventTypeWarning, "PreFlightChecksFailed", errCheckingPreReqs.Error())
+		}
 		switch e := errCheckingPreReqs.(type) {
 
 		case ErrEksClusterNotFound:
 			r.Log.Error(e, fmt.Sprintf("%v: %v eks cluster "+
 				"does not exist", req.NamespacedName, cr.Spec.ClusterName))
-			return ctrl.Result{}, updateCrPhase(agillappsv1alpha1.Failed, r.Client, cr)
+			updateCrPhase(agillappsv1alpha1.Failed, cr)
+			return ctrl.Result{}, nil
 
 		case ErrEksClusterNotActive:
 			r.Log.Info(fmt.Sprintf("%v: %v eks cluster is not in active state."+
@@ -103,60 +276,263 @@ func (r *FargateProfileReconciler) Reconcile(req ctrl.Request) (ctrl.Result, err
 		case ErrPodExecutionRoleArnNotFound:
 			r.Log.Info(fmt.Sprintf("%v: %v pod execution role arn does not exist."+
 				"Please update spec with correct podExecutionRoleArn", req.NamespacedName, cr.Spec.PodExecutionRoleArn))
-			return ctrl.Result{}, updateCrPhase(agillappsv1alpha1.Failed, r.Client, cr)
+			updateCrPhase(agillappsv1alpha1.Failed, cr)
+			return ctrl.Result{}, nil
 
 		case ErrInvalidSubnet:
 			r.Log.Error(e, fmt.Sprintf("%v: has invalid subnets - %v. "+
 				"Please update spec with correct subnets", req.NamespacedName, e.Message))
-			return ctrl.Result{}, updateCrPhase(agillappsv1alpha1.Failed, r.Client, cr)
+			updateCrPhase(agillappsv1alpha1.Failed, cr)
+			return ctrl.Result{}, nil
 
 		default:
 			r.Log.Error(e, "Something went wrong while running pre-flight checks")
-			return ctrl.Result{}, updateCrPhase(agillappsv1alpha1.Failed, r.Client, cr)
+			if isRetryableAwsErr(e) {
+				return ctrl.Result{}, e
+			}
+			updateCrPhase(agillappsv1alpha1.Failed, cr)
+			return ctrl.Result{}, nil
 		}
 	}
 
 	// describe fProfile
-	fpState, errDescribingFp := eksClient.DescribeFargateProfile(&eks.DescribeFargateProfileInput{
-		ClusterName:        aws.String(cr.Spec.ClusterName),
-		FargateProfileName: aws.String(cr.GetName()),
-	})
+	fpState, fpExists, errDescribingFp := fProfileExists(ctx, cr.Spec.ClusterName, fpName, eksClient)
 	if errDescribingFp != nil {
-
-		if awsErr, isAwsErr := errDescribingFp.(awserr.Error); isAwsErr {
-			// not found, create it
-			if awsErr.Code() == eks.ErrCodeResourceNotFoundException {
-				if errCreatingFProfile := createFProfile(cr.WithCreateIn(), eksClient); errCreatingFProfile != nil {
-					r.Log.Error(errCreatingFProfile, "Failed to create fargate-profile")
-					return ctrl.Result{}, errCreatingFProfile
-				}
-				r.Log.Info(fmt.Sprintf("%s: Creating fargate-profile", req.NamespacedName.String()))
-				return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, updateCrPhase(agillappsv1alpha1.Creating, r.Client, cr)
-			}
-		}
 		// not-recognized error, requeue
 		r.Log.Error(errDescribingFp, "Failed to describe fargate-profile")
+		setCondition(cr, agillappsv1alpha1.ProfileActive, metav1.ConditionUnknown, "DescribeFailed", errDescribingFp.Error())
 		return ctrl.Result{}, errDescribingFp
 	}
 
-	currentFpStatus := *fpState.FargateProfile.Status
-	if currentFpStatus != eks.FargateProfileStatusActive {
+	// not found, create it
+	if !fpExists {
+		if fProfileDisappeared(cr) {
+			if result, recreate, errReconciling := r.reconcileDisappeared(cr, fpName); !recreate {
+				return result, errReconciling
+			}
+		}
+		if !r.acquireClusterLock(cr) {
+			return ctrl.Result{RequeueAfter: clusterLockRetryInterval}, nil
+		}
+		createdFp, errCreatingFProfile := createFProfile(ctx, cr.WithCreateIn(fpName, r.ControllerID, r.DefaultTags), eksClient)
+		if errCreatingFProfile != nil {
+			// nothing is in flight on the cluster, let the other CRs go, e.g. to free up the profile quota
+			r.releaseClusterLock(req.NamespacedName)
+			r.Log.Error(errCreatingFProfile, "Failed to create fargate-profile")
+			if !isHandledAwsErr(errCreatingFProfile) {
+				r.Recorder.Event(cr, corev1.EventTypeWarning, "CreateFailed", errCreatingFProfile.Error())
+				setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionFalse, "CreateFailed", errCreatingFProfile.Error())
+			}
+			return ctrl.Result{}, errCreatingFProfile
+		}
+		cr.Status.FargateProfileName = fpName
+		cr.Status.LastAppliedSpecHash = specHash(cr.Spec)
+		setAwsStatus(cr, createdFp)
+		setCondition(cr, agillappsv1alpha1.ProfileActive, metav1.ConditionFalse, "Creating", fmt.Sprintf("%v fargate-profile is being created", fpName))
+		setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionFalse, "Creating", fmt.Sprintf("%v fargate-profile is being created", fpName))
+		r.Log.Info(fmt.Sprintf("%s: Creating fargate-profile", req.NamespacedName.String()))
+		r.Recorder.Event(cr, corev1.EventTypeNormal, "Creating", fmt.Sprintf("Creating fargate-profile %v", fpName))
+		updateCrPhase(agillappsv1alpha1.Creating, cr)
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Minute}, nil
+	}
+
+	// a profile this CR did not create is only touched according to the adoption policy
+	if !isManagedFProfile(cr, fpState, r.ControllerID) {
+		if result, adopted, errAdopting := r.reconcileAdoption(ctx, cr, fpState, eksClient); !adopted {
+			return result, errAdopting
+		}
+	} else if errClaiming := r.claimUntaggedFProfile(ctx, cr, fpState, eksClient); errClaiming != nil {
+		return ctrl.Result{}, errClaiming
+	}
+
+	currentFpStatus := fpState.Status
+	setAwsStatus(cr, fpState)
+	if currentFpStatus == types.FargateProfileStatusActive {
+		setCondition(cr, agillappsv1alpha1.ProfileActive, metav1.ConditionTrue, "Active", fmt.Sprintf("%v fargate-profile is active", fpName))
+	} else {
+		setCondition(cr, agillappsv1alpha1.ProfileActive, metav1.ConditionFalse, awsStatusToReason(string(currentFpStatus)),
+			fmt.Sprintf("%v fargate-profile is %v", fpName, currentFpStatus))
+	}
+
+	desiredFp := cr.WithCreateIn(fpName, r.ControllerID, r.DefaultTags)
+	outOfBand := isOutOfBandDrift(cr)
+	if outOfBand {
+		drift := fProfileDrift(desiredFp, fpState)
+		r.reportDrift(cr, fpName, drift)
+		if len(drift) > 0 && driftPolicy(cr) == agillappsv1alpha1.Report {
+			setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionFalse, "Drifted",
+				fmt.Sprintf("%v fargate-profile was changed outside of the controller", fpName))
+			return r.auditResult(), nil
+		}
+	}
+
+	// selectors, subnets and podExecutionRoleArn cannot be updated on AWS side,
+	// so when they change the profile gets deleted and the create path above recreates it
+	if fProfileNeedsReplacement(desiredFp, fpState) {
+		setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionFalse, "Replacing",
+			"selectors, subnets or podExecutionRoleArn changed, fargate-profile is being replaced")
+		if cr.Spec.UpdateStrategy == agillappsv1alpha1.BlueGreen {
+			return r.replaceBlueGreen(ctx, cr, fpName, eksClient)
+		}
+		if currentFpStatus == types.FargateProfileStatusCreating || currentFpStatus == types.FargateProfileStatusDeleting {
+			r.Log.Info(fmt.Sprintf("%s: fargate-profile needs to be replaced, waiting for it to settle. Current status: %v", req.NamespacedName.String(), currentFpStatus))
+			updateCrPhase(agillappsv1alpha1.Replacing, cr)
+			return ctrl.Result{RequeueAfter: time.Minute, Requeue: true}, nil
+		}
+		if !r.acquireClusterLock(cr) {
+			return ctrl.Result{RequeueAfter: clusterLockRetryInterval}, nil
+		}
+		if errDeletingFprofile := deleteFprofile(ctx, cr.WithDeleteIn(fpName), eksClient); errDeletingFprofile != nil {
+			r.Log.Error(errDeletingFprofile, "Failed to delete fargate-profile for replacement")
+			r.releaseClusterLock(req.NamespacedName)
+			return ctrl.Result{}, errDeletingFprofile
+		}
+		r.Log.Info(fmt.Sprintf("%s: Spec changed, replacing fargate-profile", req.NamespacedName.String()))
+		r.Recorder.Event(cr, corev1.EventTypeNormal, "Replacing", fmt.Sprintf("Spec changed, deleting fargate-profile %v to recreate it", fpName))
+		updateCrPhase(agillappsv1alpha1.Replacing, cr)
+		return ctrl.Result{RequeueAfter: time.Minute, Requeue: true}, nil
+	}
+
+	// nothing is running on AWS side anymore, let the other CRs of the cluster go and wait for a spec change
+	if currentFpStatus == types.FargateProfileStatusCreateFailed {
+		r.releaseClusterLock(req.NamespacedName)
+		message := fmt.Sprintf("%v fargate-profile failed to create: %v", fpName, fProfileHealthIssues(fpState))
+		r.Log.Info(fmt.Sprintf("%s: %v", req.NamespacedName.String(), message))
+		if cr.Status.Phase != agillappsv1alpha1.Failed {
+			r.Recorder.Event(cr, corev1.EventTypeWarning, "CreateFailed", message)
+		}
+		setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionFalse, "CreateFailed", message)
+		updateCrPhase(agillappsv1alpha1.Failed, cr)
+		return ctrl.Result{}, nil
+	}
+	if currentFpStatus != types.FargateProfileStatusActive {
+		setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionFalse, awsStatusToReason(string(currentFpStatus)),
+			fmt.Sprintf("waiting for %v fargate-profile to become active", fpName))
 		r.Log.Info(fmt.Sprintf("%s: fargate-profile is not active yet. Current status: %v", req.NamespacedName.String(), currentFpStatus))
 		return ctrl.Result{RequeueAfter: time.Minute, Requeue: true}, nil
 	}
+	if errReconcilingTags := r.reconcileTags(ctx, cr, fpState, eksClient); errReconcilingTags != nil {
+		return ctrl.Result{}, errReconcilingTags
+	}
+	// a rollout abandoned because the spec went back to what the current profile already matches
+	if result, cleanedUp, errCleaningUp := r.cleanUpPendingFProfile(ctx, cr, eksClient); !cleanedUp {
+		return result, errCleaningUp
+	}
+	// the cluster lock is only released once the profile replaced by a blue/green rollout is gone
+	if result, deleted, errWaiting := r.waitForReplacedFProfile(ctx, cr, eksClient); !deleted {
+		return result, errWaiting
+	}
+	setCondition(cr, agillappsv1alpha1.Synced, metav1.ConditionTrue, "InSync", fmt.Sprintf("%v fargate-profile matches spec", fpName))
+	cr.Status.ObservedGeneration = cr.GetGeneration()
+	cr.Status.LastAppliedSpecHash = specHash(cr.Spec)
 	r.Log.Info(fmt.Sprintf("%v: fargate-profile is %v", req.NamespacedName, currentFpStatus))
-	return ctrl.Result{}, updateCrPhase(agillappsv1alpha1.Ready, r.Client, cr)
+	if cr.Status.Phase != agillappsv1alpha1.Ready {
+		r.Recorder.Event(cr, corev1.EventTypeNormal, "Active", fmt.Sprintf("fargate-profile %v is active", fpName))
+	}
+	if cr.Status.Phase == agillappsv1alpha1.Creating || cr.Status.Phase == agillappsv1alpha1.Replacing {
+		observeCreateDuration(cr)
+	}
+	r.releaseClusterLock(req.NamespacedName)
+	updateCrPhase(agillappsv1alpha1.Ready, cr)
+	return r.auditResult(), nil
+}
+
+// auditResult requeues a settled CR so changes made to its fargate-profile outside of the controller are noticed
+func (r *FargateProfileReconciler) auditResult() ctrl.Result {
+	return ctrl.Result{RequeueAfter: r.AuditInterval}
+}
+
+// applySpecDefaults sets the default region and cluster name on a CR that does not specify them
+// and reports whether the spec changed
+func (r *FargateProfileReconciler) applySpecDefaults(cr *agillappsv1alpha1.FargateProfile) bool {
+	changed := false
+	if cr.Spec.Region == "" && r.DefaultRegion != "" {
+		cr.Spec.Region = r.DefaultRegion
+		changed = true
+	}
+	if cr.Spec.ClusterName == "" && r.DefaultClusterName != "" {
+		cr.Spec.ClusterName = r.DefaultClusterName
+		changed = true
+	}
+	return changed
+}
+
+// validateRequiredFields rejects a CR whose region or cluster name is neither set nor defaulted
+func validateRequiredFields(cr *agillappsv1alpha1.FargateProfile) error {
+	var missing []string
+	if cr.Spec.Region == "" {
+		missing = append(missing, "spec.region")
+	}
+	if cr.Spec.ClusterName == "" {
+		missing = append(missing, "spec.clusterName")
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return ErrMissingRequiredField{Message: fmt.Sprintf("%v must be set, the controller has no default for it", strings.Join(missing, " and "))}
+}
+
+// deletionPolicy returns what to do with the fargate-profile on AWS side once the CR is deleted
+func (r *FargateProfileReconciler) deletionPolicy(cr *agillappsv1alpha1.FargateProfile) agillappsv1alpha1.DeletionPolicy {
+	if cr.Spec.DeletionPolicy != "" {
+		return cr.Spec.DeletionPolicy
+	}
+	if r.DefaultDeletionPolicy != "" {
+		return r.DefaultDeletionPolicy
+	}
+	return agillappsv1alpha1.Delete
+}
+
+// reportRetainedFProfiles logs and records an event for every fargate-profile left on AWS side because
+// deletionPolicy is Retain, including the one of a blue/green rollout still in flight
+func (r *FargateProfileReconciler) reportRetainedFProfiles(cr *agillappsv1alpha1.FargateProfile, fpName string) {
+	crName := fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName())
+	if fpName != "" {
+		r.Log.Info(fmt.Sprintf("%s: deletionPolicy is Retain, leaving fargate-profile %v on AWS side", crName, fpName))
+		r.Recorder.Event(cr, corev1.EventTypeNormal, "Orphaned", fmt.Sprintf("Retained fargate-profile %v on AWS side because deletionPolicy is %v", fpName, agillappsv1alpha1.Retain))
+	}
+	if pendingName := cr.Status.PendingFargateProfileName; pendingName != "" {
+		r.Log.Info(fmt.Sprintf("%s: deletionPolicy is Retain, leaving fargate-profile %v of an unfinished blue/green rollout on AWS side", crName, pendingName))
+		r.Recorder.Event(cr, corev1.EventTypeNormal, "Orphaned", fmt.Sprintf("Retained fargate-profile %v of an unfinished blue/green rollout "+
+			"on AWS side because deletionPolicy is %v", pendingName, agillappsv1alpha1.Retain))
+	}
+}
+
+// reconcileContext returns the context the AWS calls of one reconcile run with
+func (r *FargateProfileReconciler) reconcileContext() (context.Context, context.CancelFunc) {
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if r.ReconcileTimeout > 0 {
+		return context.WithTimeout(ctx, r.ReconcileTimeout)
+	}
+	return context.WithCancel(ctx)
 }
 
 func (r *FargateProfileReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	var cancel context.CancelFunc
+	r.ctx, cancel = context.WithCancel(context.Background())
+	if errAdding := mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+		return nil
+	})); errAdding != nil {
+		cancel()
+		return errAdding
+	}
+
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&agillappsv1alpha1.FargateProfile{}).
-		WithEventFilter(predicate.Funcs{
+		For(&agillappsv1alpha1.FargateProfile{}, builder.WithPredicates(predicate.Funcs{
 
-			// must return true to let this event reconcile
+			// must return true to let this event reconcile, settled CRs are audited through AuditInterval instead of resyncs
 			UpdateFunc: func(e event.UpdateEvent) bool {
 				return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration()
 			},
-		}).
+		})).
 		Complete(r)
 }
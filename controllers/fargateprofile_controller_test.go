@@ -0,0 +1,773 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ktypes "k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testVpcID         = "vpc-0123abcd"
+	testAuditInterval = 5 * time.Minute
+)
+
+// fakeEks keeps fargate-profiles in memory, like EKS they go through CREATING and DELETING
+// and the test moves them along with settle
+type fakeEks struct {
+	profiles map[string]*types.FargateProfile
+	// createErr is returned by CreateFargateProfile when set
+	createErr error
+}
+
+func (f *fakeEks) DescribeCluster(_ context.Context, in *eks.DescribeClusterInput, _ ...func(*eks.Options)) (*eks.DescribeClusterOutput, error) {
+	return &eks.DescribeClusterOutput{Cluster: &types.Cluster{
+		Name:               in.Name,
+		Status:             types.ClusterStatusActive,
+		ResourcesVpcConfig: &types.VpcConfigResponse{VpcId: aws.String(testVpcID)},
+	}}, nil
+}
+
+func (f *fakeEks) DescribeFargateProfile(_ context.Context, in *eks.DescribeFargateProfileInput, _ ...func(*eks.Options)) (*eks.DescribeFargateProfileOutput, error) {
+	fp, found := f.profiles[aws.ToString(in.FargateProfileName)]
+	if !found {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	fpCopy := *fp
+	fpCopy.Tags = map[string]string{}
+	for key, value := range fp.Tags {
+		fpCopy.Tags[key] = value
+	}
+	return &eks.DescribeFargateProfileOutput{FargateProfile: &fpCopy}, nil
+}
+
+func (f *fakeEks) CreateFargateProfile(_ context.Context, in *eks.CreateFargateProfileInput, _ ...func(*eks.Options)) (*eks.CreateFargateProfileOutput, error) {
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	name := aws.ToString(in.FargateProfileName)
+	if _, found := f.profiles[name]; found {
+		return nil, &types.ResourceInUseException{Message: aws.String("already exists")}
+	}
+	fp := &types.FargateProfile{
+		ClusterName:         in.ClusterName,
+		FargateProfileName:  in.FargateProfileName,
+		FargateProfileArn:   aws.String("arn:aws:eks:us-east-1:123456789012:fargateprofile/prod/" + name),
+		PodExecutionRoleArn: in.PodExecutionRoleArn,
+		Selectors:           in.Selectors,
+		Subnets:             in.Subnets,
+		Tags:                in.Tags,
+		Status:              types.FargateProfileStatusCreating,
+		CreatedAt:           aws.Time(time.Now()),
+	}
+	f.profiles[name] = fp
+	return &eks.CreateFargateProfileOutput{FargateProfile: fp}, nil
+}
+
+func (f *fakeEks) DeleteFargateProfile(_ context.Context, in *eks.DeleteFargateProfileInput, _ ...func(*eks.Options)) (*eks.DeleteFargateProfileOutput, error) {
+	fp, found := f.profiles[aws.ToString(in.FargateProfileName)]
+	if !found {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+	}
+	fp.Status = types.FargateProfileStatusDeleting
+	return &eks.DeleteFargateProfileOutput{FargateProfile: fp}, nil
+}
+
+func (f *fakeEks) TagResource(_ context.Context, in *eks.TagResourceInput, _ ...func(*eks.Options)) (*eks.TagResourceOutput, error) {
+	fp, errFinding := f.byArn(aws.ToString(in.ResourceArn))
+	if errFinding != nil {
+		return nil, errFinding
+	}
+	for key, value := range in.Tags {
+		fp.Tags[key] = value
+	}
+	return &eks.TagResourceOutput{}, nil
+}
+
+func (f *fakeEks) UntagResource(_ context.Context, in *eks.UntagResourceInput, _ ...func(*eks.Options)) (*eks.UntagResourceOutput, error) {
+	fp, errFinding := f.byArn(aws.ToString(in.ResourceArn))
+	if errFinding != nil {
+		return nil, errFinding
+	}
+	for _, key := range in.TagKeys {
+		delete(fp.Tags, key)
+	}
+	return &eks.UntagResourceOutput{}, nil
+}
+
+func (f *fakeEks) byArn(arn string) (*types.FargateProfile, error) {
+	for _, fp := range f.profiles {
+		if aws.ToString(fp.FargateProfileArn) == arn {
+			return fp, nil
+		}
+	}
+	return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+}
+
+// settle finishes whatever EKS is doing with the fargate-profile
+func (f *fakeEks) settle(name string) {
+	fp, found := f.profiles[name]
+	if !found {
+		return
+	}
+	switch fp.Status {
+	case types.FargateProfileStatusCreating:
+		fp.Status = types.FargateProfileStatusActive
+	case types.FargateProfileStatusDeleting:
+		delete(f.profiles, name)
+	}
+}
+
+// failCreate moves a CREATING fargate-profile to CREATE_FAILED the way EKS reports it
+func (f *fakeEks) failCreate(name string) {
+	fp := f.profiles[name]
+	fp.Status = types.FargateProfileStatusCreateFailed
+	fp.Health = &types.FargateProfileHealth{Issues: []types.FargateProfileIssue{{
+		Code:    types.FargateProfileIssueCodePodExecutionRoleAlreadyInUse,
+		Message: aws.String("role is used by another cluster"),
+	}}}
+}
+
+// fakeEc2 knows one VPC whose main route table routes through a NAT gateway
+type fakeEc2 struct{}
+
+func (fakeEc2) DescribeRouteTables(context.Context, *ec2.DescribeRouteTablesInput, ...func(*ec2.Options)) (*ec2.DescribeRouteTablesOutput, error) {
+	return &ec2.DescribeRouteTablesOutput{RouteTables: []ec2types.RouteTable{{
+		VpcId:        aws.String(testVpcID),
+		Associations: []ec2types.RouteTableAssociation{{Main: aws.Bool(true)}},
+		Routes:       []ec2types.Route{{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-123")}},
+	}}}, nil
+}
+
+func (fakeEc2) DescribeSubnets(_ context.Context, in *ec2.DescribeSubnetsInput, _ ...func(*ec2.Options)) (*ec2.DescribeSubnetsOutput, error) {
+	var subnets []ec2types.Subnet
+	for _, filter := range in.Filters {
+		for _, subnetID := range filter.Values {
+			subnets = append(subnets, ec2types.Subnet{SubnetId: aws.String(subnetID), VpcId: aws.String(testVpcID)})
+		}
+	}
+	return &ec2.DescribeSubnetsOutput{Subnets: subnets}, nil
+}
+
+type fakeIam struct{}
+
+func (fakeIam) GetRole(_ context.Context, in *iam.GetRoleInput, _ ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	return &iam.GetRoleOutput{Role: &iamtypes.Role{RoleName: in.RoleName}}, nil
+}
+
+type fakeAwsClientFactory struct {
+	clients AwsClients
+}
+
+func (f fakeAwsClientFactory) ClientsFor(context.Context, AwsSessionConfig) (AwsClients, error) {
+	return f.clients, nil
+}
+
+// statusWriteCounter counts the status writes made through the client
+type statusWriteCounter struct {
+	client.Client
+	writes int
+}
+
+func (c *statusWriteCounter) Status() client.StatusWriter {
+	return countingStatusWriter{StatusWriter: c.Client.Status(), counter: c}
+}
+
+type countingStatusWriter struct {
+	client.StatusWriter
+	counter *statusWriteCounter
+}
+
+func (w countingStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	w.counter.writes++
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w countingStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	w.counter.writes++
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
+
+func testNsName(name string) ktypes.NamespacedName {
+	return ktypes.NamespacedName{Namespace: "default", Name: name}
+}
+
+type reconcileTest struct {
+	t          *testing.T
+	client     client.Client
+	eks        *fakeEks
+	recorder   *record.FakeRecorder
+	reconciler *FargateProfileReconciler
+	nsName     ktypes.NamespacedName
+}
+
+func newReconcileTest(t *testing.T, updateStrategy v1alpha1.UpdateStrategy) *reconcileTest {
+	scheme := runtime.NewScheme()
+	if errAdding := clientgoscheme.AddToScheme(scheme); errAdding != nil {
+		t.Fatal(errAdding)
+	}
+	if errAdding := v1alpha1.AddToScheme(scheme); errAdding != nil {
+		t.Fatal(errAdding)
+	}
+
+	cr := &v1alpha1.FargateProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-1", Generation: 1},
+		Spec: v1alpha1.FargateProfileSpec{
+			Region:              "us-east-1",
+			ClusterName:         "prod",
+			PodExecutionRoleArn: "arn:aws:iam::123456789012:role/fargate",
+			Subnets:             []string{"subnet-0123abcd", "subnet-4567cdef"},
+			Selectors:           []v1alpha1.FargateProfileSelector{{Namespace: "web", Labels: map[string]string{"app": "web"}}},
+			Tags:                map[string]string{"team": "web"},
+			UpdateStrategy:      updateStrategy,
+		},
+	}
+	k8sClient := fake.NewFakeClientWithScheme(scheme, cr)
+	fakeEksClient := &fakeEks{profiles: map[string]*types.FargateProfile{}}
+	recorder := record.NewFakeRecorder(1000)
+
+	return &reconcileTest{
+		t:        t,
+		client:   k8sClient,
+		eks:      fakeEksClient,
+		recorder: recorder,
+		nsName:   testNsName("web"),
+		reconciler: &FargateProfileReconciler{
+			Client:        k8sClient,
+			APIReader:     k8sClient,
+			Log:           ctrl.Log.WithName("test"),
+			Scheme:        scheme,
+			Recorder:      recorder,
+			AwsClients:    fakeAwsClientFactory{clients: AwsClients{Eks: fakeEksClient, Ec2: fakeEc2{}, Iam: fakeIam{}}},
+			Backoff:       NewRequeueBackoff(RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute}),
+			ClusterLocks:  NewClusterLocks(),
+			ControllerID:  "test",
+			AuditInterval: testAuditInterval,
+		},
+	}
+}
+
+func (rt *reconcileTest) reconcile() *v1alpha1.FargateProfile {
+	rt.t.Helper()
+	_, cr := rt.reconcileResult()
+	return cr
+}
+
+func (rt *reconcileTest) reconcileResult() (ctrl.Result, *v1alpha1.FargateProfile) {
+	rt.t.Helper()
+	result, errReconciling := rt.reconciler.Reconcile(ctrl.Request{NamespacedName: rt.nsName})
+	if errReconciling != nil {
+		rt.t.Fatalf("reconcile failed: %v", errReconciling)
+	}
+	return result, rt.get()
+}
+
+func (rt *reconcileTest) get() *v1alpha1.FargateProfile {
+	rt.t.Helper()
+	cr := &v1alpha1.FargateProfile{}
+	if errGetting := rt.client.Get(context.TODO(), rt.nsName, cr); errGetting != nil {
+		rt.t.Fatalf("failed to get the CR: %v", errGetting)
+	}
+	return cr
+}
+
+// events returns the events recorded since the last call, formatted as "type reason message"
+func (rt *reconcileTest) events() []string {
+	var events []string
+	for {
+		select {
+		case event := <-rt.recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// hasEvent reports whether one of the events has the given type and reason
+func hasEvent(events []string, eventType, reason string) bool {
+	for _, event := range events {
+		if strings.HasPrefix(event, eventType+" "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt *reconcileTest) update(mutate func(cr *v1alpha1.FargateProfile)) {
+	rt.t.Helper()
+	cr := rt.get()
+	mutate(cr)
+	if errUpdating := rt.client.Update(context.TODO(), cr); errUpdating != nil {
+		rt.t.Fatalf("failed to update the CR: %v", errUpdating)
+	}
+}
+
+// createReady reconciles the CR until its fargate-profile is ACTIVE
+func (rt *reconcileTest) createReady() *v1alpha1.FargateProfile {
+	rt.t.Helper()
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Creating {
+		rt.t.Fatalf("expected phase %v, got %v", v1alpha1.Creating, cr.Status.Phase)
+	}
+	if _, found := rt.eks.profiles["web"]; !found {
+		rt.t.Fatal("expected the web fargate-profile to be created")
+	}
+	rt.eks.settle("web")
+	cr = rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Ready {
+		rt.t.Fatalf("expected phase %v, got %v", v1alpha1.Ready, cr.Status.Phase)
+	}
+	return cr
+}
+
+func TestReconcileCreate(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	cr := rt.createReady()
+
+	fp := rt.eks.profiles["web"]
+	if !cr.IsOwnerOf(fp.Tags, "test") || fp.Tags["team"] != "web" {
+		t.Errorf("expected spec and ownership tags on the fargate-profile, got %v", fp.Tags)
+	}
+	if cr.Status.FargateProfileName != "web" || cr.Status.FargateProfileArn != aws.ToString(fp.FargateProfileArn) {
+		t.Errorf("expected the fargate-profile in status, got %+v", cr.Status)
+	}
+	if cr.Status.ObservedGeneration != 1 {
+		t.Errorf("expected observed generation 1, got %v", cr.Status.ObservedGeneration)
+	}
+	if len(cr.GetFinalizers()) != 1 {
+		t.Errorf("expected the finalizer, got %v", cr.GetFinalizers())
+	}
+}
+
+func TestReconcileAppliedSpecHash(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	cr := rt.createReady()
+	if cr.Status.LastAppliedSpecHash != specHash(cr.Spec) {
+		t.Fatalf("expected the hash of the created spec, got %q", cr.Status.LastAppliedSpecHash)
+	}
+
+	// tags are updated in place, the hash follows the observed generation
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Tags = map[string]string{"team": "data"}
+		cr.Generation++
+	})
+	cr = rt.reconcile()
+	if cr.Status.ObservedGeneration != 2 || cr.Status.LastAppliedSpecHash != specHash(cr.Spec) {
+		t.Errorf("expected generation 2 and the hash of its spec, got %v and %q", cr.Status.ObservedGeneration, cr.Status.LastAppliedSpecHash)
+	}
+}
+
+func TestReconcileWritesStatusOnce(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	counter := &statusWriteCounter{Client: rt.client}
+	rt.reconciler.Client = counter
+
+	// phase and conditions both change on create and on going ACTIVE, they are still written together
+	rt.reconcile()
+	if counter.writes != 1 {
+		t.Errorf("expected one status write while creating, got %d", counter.writes)
+	}
+	rt.eks.settle("web")
+	counter.writes = 0
+	rt.reconcile()
+	if counter.writes != 1 {
+		t.Errorf("expected one status write once ACTIVE, got %d", counter.writes)
+	}
+	// the first audit adds the Drifted condition, the next one has nothing to write
+	rt.reconcile()
+	counter.writes = 0
+	rt.reconcile()
+	if counter.writes != 0 {
+		t.Errorf("expected no status write when nothing changed, got %d", counter.writes)
+	}
+}
+
+func TestReconcileTagDrift(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Tags = map[string]string{"team": "data"}
+		cr.Generation++
+	})
+	rt.eks.profiles["web"].Tags["added-in-console"] = "yes"
+	cr := rt.reconcile()
+
+	fp := rt.eks.profiles["web"]
+	if fp.Tags["team"] != "data" || fp.Tags["added-in-console"] != "" {
+		t.Errorf("expected the tags to follow the spec, got %v", fp.Tags)
+	}
+	if fp.Status != types.FargateProfileStatusActive || cr.Status.Phase != v1alpha1.Ready {
+		t.Errorf("expected the fargate-profile to be updated in place, got %v and phase %v", fp.Status, cr.Status.Phase)
+	}
+}
+
+func TestReconcileRecreate(t *testing.T) {
+	rt := newReconcileTest(t, v1alpha1.Recreate)
+	rt.createReady()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Subnets = []string{"subnet-0123abcd"}
+		cr.Generation++
+	})
+	if cr := rt.reconcile(); cr.Status.Phase != v1alpha1.Replacing {
+		t.Fatalf("expected phase %v, got %v", v1alpha1.Replacing, cr.Status.Phase)
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusDeleting {
+		t.Fatalf("expected the old fargate-profile to be deleted, got %v", rt.eks.profiles["web"].Status)
+	}
+
+	rt.eks.settle("web")
+	if cr := rt.reconcile(); cr.Status.Phase != v1alpha1.Creating {
+		t.Fatalf("expected phase %v, got %v", v1alpha1.Creating, cr.Status.Phase)
+	}
+	rt.eks.settle("web")
+	if cr := rt.reconcile(); cr.Status.Phase != v1alpha1.Ready || cr.Status.ObservedGeneration != 2 {
+		t.Fatalf("expected phase %v for generation 2, got %v for %v", v1alpha1.Ready, cr.Status.Phase, cr.Status.ObservedGeneration)
+	}
+	if subnets := rt.eks.profiles["web"].Subnets; len(subnets) != 1 || subnets[0] != "subnet-0123abcd" {
+		t.Errorf("expected the new subnets on the fargate-profile, got %v", subnets)
+	}
+}
+
+func TestReconcileBlueGreen(t *testing.T) {
+	rt := newReconcileTest(t, v1alpha1.BlueGreen)
+	rt.createReady()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Subnets = []string{"subnet-0123abcd"}
+		cr.Generation++
+	})
+	cr := rt.reconcile()
+	if cr.Status.PendingFargateProfileName != "web-1" || rt.eks.profiles["web-1"] == nil {
+		t.Fatalf("expected web-1 to be rolled out, got pending %q", cr.Status.PendingFargateProfileName)
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusActive {
+		t.Fatalf("expected web to keep serving pods during the rollout, got %v", rt.eks.profiles["web"].Status)
+	}
+
+	// the spec changes again before web-1 is ACTIVE, web-1 must not be orphaned
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Subnets = []string{"subnet-4567cdef"}
+		cr.Generation++
+	})
+	rt.eks.settle("web-1")
+	rt.reconcile()
+	if rt.eks.profiles["web-1"].Status != types.FargateProfileStatusDeleting {
+		t.Fatalf("expected the abandoned web-1 to be deleted, got %v", rt.eks.profiles["web-1"].Status)
+	}
+	rt.eks.settle("web-1")
+
+	cr = rt.reconcile()
+	if cr.Status.PendingFargateProfileName != "web-2" || rt.eks.profiles["web-2"] == nil {
+		t.Fatalf("expected web-2 to be rolled out, got pending %q", cr.Status.PendingFargateProfileName)
+	}
+	rt.eks.settle("web-2")
+	cr = rt.reconcile()
+	if cr.Status.FargateProfileName != "web-2" || cr.Status.PendingFargateProfileName != "" {
+		t.Fatalf("expected web-2 to replace web, got %q and pending %q", cr.Status.FargateProfileName, cr.Status.PendingFargateProfileName)
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusDeleting {
+		t.Errorf("expected web to be deleted, got %v", rt.eks.profiles["web"].Status)
+	}
+}
+
+func TestReconcileDelete(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	if cr := rt.reconcile(); cr.Status.Phase != v1alpha1.Deleting || len(cr.GetFinalizers()) != 1 {
+		t.Fatalf("expected phase %v with the finalizer kept, got %v and %v", v1alpha1.Deleting, cr.Status.Phase, cr.GetFinalizers())
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusDeleting {
+		t.Fatalf("expected the fargate-profile to be deleted, got %v", rt.eks.profiles["web"].Status)
+	}
+
+	// the finalizer is held until AWS is done deleting
+	if cr := rt.reconcile(); len(cr.GetFinalizers()) != 1 {
+		t.Fatalf("expected the finalizer to be kept while DELETING, got %v", cr.GetFinalizers())
+	}
+	rt.eks.settle("web")
+	if cr := rt.reconcile(); len(cr.GetFinalizers()) != 0 {
+		t.Fatalf("expected the finalizer to be released, got %v", cr.GetFinalizers())
+	}
+}
+
+func TestReconcileDeleteFailed(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	rt.reconcile()
+	rt.events()
+	rt.eks.profiles["web"].Status = types.FargateProfileStatusDeleteFailed
+	rt.eks.profiles["web"].Health = &types.FargateProfileHealth{Issues: []types.FargateProfileIssue{{
+		Code:        types.FargateProfileIssueCodeAccessDenied,
+		Message:     aws.String("role cannot be assumed"),
+		ResourceIds: []string{"arn:aws:iam::123456789012:role/fargate"},
+	}}}
+
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Failed || len(cr.GetFinalizers()) != 1 {
+		t.Fatalf("expected phase %v with the finalizer kept, got %v and %v", v1alpha1.Failed, cr.Status.Phase, cr.GetFinalizers())
+	}
+	cond := findCondition(cr, v1alpha1.Synced)
+	if cond == nil || cond.Reason != "DeleteFailed" || !strings.Contains(cond.Message, "AccessDenied: role cannot be assumed") {
+		t.Errorf("expected the Synced condition to carry the health issues, got %+v", cond)
+	}
+	if !hasEvent(rt.events(), corev1.EventTypeWarning, "DeleteFailed") {
+		t.Error("expected a DeleteFailed event")
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusDeleting {
+		t.Fatalf("expected the deletion to be retried, got %v", rt.eks.profiles["web"].Status)
+	}
+
+	rt.eks.settle("web")
+	if cr = rt.reconcile(); len(cr.GetFinalizers()) != 0 {
+		t.Errorf("expected the finalizer to be released once the retry went through, got %v", cr.GetFinalizers())
+	}
+}
+
+func TestReconcileDeleteRetain(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.DeletionPolicy = v1alpha1.Retain
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	if cr := rt.reconcile(); len(cr.GetFinalizers()) != 0 {
+		t.Fatalf("expected the finalizer to be released, got %v", cr.GetFinalizers())
+	}
+	if rt.eks.profiles["web"].Status != types.FargateProfileStatusActive {
+		t.Errorf("expected the fargate-profile to be retained, got %v", rt.eks.profiles["web"].Status)
+	}
+}
+
+func TestReconcileDeleteRetainDuringBlueGreen(t *testing.T) {
+	rt := newReconcileTest(t, v1alpha1.BlueGreen)
+	rt.createReady()
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Subnets = []string{"subnet-0123abcd"}
+		cr.Generation++
+	})
+	rt.reconcile()
+	rt.events()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.DeletionPolicy = v1alpha1.Retain
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	if cr := rt.reconcile(); len(cr.GetFinalizers()) != 0 {
+		t.Fatalf("expected the finalizer to be released, got %v", cr.GetFinalizers())
+	}
+	if rt.eks.profiles["web"] == nil || rt.eks.profiles["web-1"] == nil {
+		t.Fatalf("expected both fargate-profiles to be retained, got %v", rt.eks.profiles)
+	}
+	// the profile of the unfinished rollout is named so it is not orphaned silently
+	orphaned := map[string]bool{}
+	for _, event := range rt.events() {
+		for _, name := range []string{"web", "web-1"} {
+			if strings.HasPrefix(event, corev1.EventTypeNormal+" Orphaned Retained fargate-profile "+name+" ") {
+				orphaned[name] = true
+			}
+		}
+	}
+	if !orphaned["web"] || !orphaned["web-1"] {
+		t.Errorf("expected an Orphaned event for web and web-1, got %v", orphaned)
+	}
+}
+
+func TestReconcileLegacyProfile(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	// a profile created by a controller version without ownership tags or status.fargateProfileName
+	rt.eks.profiles["web"] = &types.FargateProfile{
+		FargateProfileName:  aws.String("web"),
+		FargateProfileArn:   aws.String("arn:aws:eks:us-east-1:123456789012:fargateprofile/prod/web"),
+		PodExecutionRoleArn: aws.String("arn:aws:iam::123456789012:role/fargate"),
+		Subnets:             []string{"subnet-0123abcd", "subnet-4567cdef"},
+		Selectors:           []types.FargateProfileSelector{{Namespace: aws.String("web"), Labels: map[string]string{"app": "web"}}},
+		Tags:                map[string]string{"team": "web"},
+		Status:              types.FargateProfileStatusActive,
+	}
+	cr := rt.get()
+	cr.Status.Phase = v1alpha1.Ready
+	if errUpdating := rt.client.Status().Update(context.TODO(), cr); errUpdating != nil {
+		t.Fatal(errUpdating)
+	}
+
+	cr = rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Ready || cr.Status.FargateProfileName != "web" {
+		t.Fatalf("expected the legacy fargate-profile to stay managed, got phase %v and name %q", cr.Status.Phase, cr.Status.FargateProfileName)
+	}
+	if !cr.IsOwnerOf(rt.eks.profiles["web"].Tags, "test") {
+		t.Errorf("expected the legacy fargate-profile to be tagged, got %v", rt.eks.profiles["web"].Tags)
+	}
+}
+
+func TestClusterLockIsNotExtendedByRetries(t *testing.T) {
+	locks := NewClusterLocks()
+	first, second := testNsName("first"), testNsName("second")
+	if _, acquired := locks.TryAcquire("us-east-1/prod", first); !acquired {
+		t.Fatal("expected the first CR to get the lock")
+	}
+	if holder, acquired := locks.TryAcquire("us-east-1/prod", second); acquired || holder != first {
+		t.Fatalf("expected the second CR to wait for %v, got %v", first, holder)
+	}
+
+	// retries of the holder keep the original acquisition time, so the TTL eventually runs out
+	expired := time.Now().Add(-clusterLockTTL - time.Second)
+	locks.holders["us-east-1/prod"] = clusterLockHolder{owner: first, acquired: time.Now().Add(-clusterLockTTL + time.Minute)}
+	locks.TryAcquire("us-east-1/prod", first)
+	if locks.holders["us-east-1/prod"].acquired.After(time.Now().Add(-clusterLockTTL + time.Minute)) {
+		t.Fatal("expected a retry of the holder not to extend the lock")
+	}
+	locks.holders["us-east-1/prod"] = clusterLockHolder{owner: first, acquired: expired}
+	if _, acquired := locks.TryAcquire("us-east-1/prod", second); !acquired {
+		t.Errorf("expected the lock of %v to expire", first)
+	}
+
+	locks.Release(second)
+	if _, acquired := locks.TryAcquire("us-east-1/prod", first); !acquired {
+		t.Error("expected the lock to be free once released")
+	}
+}
+
+func TestReconcileSpecDefaults(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Region = ""
+		cr.Spec.ClusterName = ""
+	})
+	rt.reconciler.DefaultRegion = "us-east-1"
+	rt.reconciler.DefaultClusterName = "prod"
+
+	cr := rt.reconcile()
+	if cr.Spec.Region != "us-east-1" || cr.Spec.ClusterName != "prod" {
+		t.Fatalf("expected the controller defaults in spec, got %q and %q", cr.Spec.Region, cr.Spec.ClusterName)
+	}
+	if cr.Status.Phase != v1alpha1.Creating || rt.eks.profiles["web"] == nil {
+		t.Errorf("expected the fargate-profile to be created, got phase %v", cr.Status.Phase)
+	}
+}
+
+func TestReconcileMissingRequiredFields(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.ClusterName = ""
+	})
+
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Failed {
+		t.Fatalf("expected phase %v, got %v", v1alpha1.Failed, cr.Status.Phase)
+	}
+	if cond := findCondition(cr, v1alpha1.Synced); cond == nil || cond.Reason != (ErrMissingRequiredField{}).Reason() {
+		t.Errorf("expected the Synced condition to report the missing field, got %+v", cond)
+	}
+	if len(rt.eks.profiles) != 0 {
+		t.Errorf("expected nothing to be created, got %v", rt.eks.profiles)
+	}
+}
+
+func TestReconcileBlueGreenHoldsClusterLock(t *testing.T) {
+	rt := newReconcileTest(t, v1alpha1.BlueGreen)
+	rt.createReady()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Subnets = []string{"subnet-0123abcd"}
+		cr.Generation++
+	})
+	rt.reconcile()
+	newName := rt.get().Status.PendingFargateProfileName
+	rt.eks.settle(newName)
+	if cr := rt.reconcile(); cr.Status.ReplacedFargateProfileName != "web" {
+		t.Fatalf("expected web to be tracked until it is deleted, got %q", cr.Status.ReplacedFargateProfileName)
+	}
+
+	// web is still DELETING, another CR of the cluster must keep waiting
+	other := testNsName("other")
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Replacing {
+		t.Fatalf("expected phase %v while web is deleted, got %v", v1alpha1.Replacing, cr.Status.Phase)
+	}
+	if _, acquired := rt.reconciler.ClusterLocks.TryAcquire("us-east-1/prod", other); acquired {
+		t.Fatal("expected the cluster lock to be held while web is DELETING")
+	}
+
+	rt.eks.settle("web")
+	cr = rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Ready || cr.Status.ReplacedFargateProfileName != "" {
+		t.Fatalf("expected phase %v once web is gone, got %v and replaced %q", v1alpha1.Ready, cr.Status.Phase, cr.Status.ReplacedFargateProfileName)
+	}
+	if _, acquired := rt.reconciler.ClusterLocks.TryAcquire("us-east-1/prod", other); !acquired {
+		t.Error("expected the cluster lock to be released")
+	}
+}
+
+func TestReconcileCreateFailedReleasesClusterLock(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.reconcile()
+	rt.events()
+	rt.eks.failCreate("web")
+
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Failed {
+		t.Fatalf("expected phase %v, got %v", v1alpha1.Failed, cr.Status.Phase)
+	}
+	if cond := findCondition(cr, v1alpha1.Synced); cond == nil || cond.Reason != "CreateFailed" || !strings.Contains(cond.Message, "role is used by another cluster") {
+		t.Errorf("expected the Synced condition to carry the health issues, got %+v", cond)
+	}
+	if !hasEvent(rt.events(), corev1.EventTypeWarning, "CreateFailed") {
+		t.Error("expected a CreateFailed event")
+	}
+	if _, acquired := rt.reconciler.ClusterLocks.TryAcquire("us-east-1/prod", testNsName("other")); !acquired {
+		t.Error("expected the cluster lock to be released")
+	}
+}
+
+func TestReconcileBlueGreenCreateFailedReleasesClusterLock(t *testing.T) {
+	rt := newReconcileTest(t, v1alpha1.BlueGreen)
+	rt.createReady()
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Subnets = []string{"subnet-0123abcd"}
+		cr.Generation++
+	})
+	rt.reconcile()
+	rt.eks.failCreate("web-1")
+
+	cr := rt.reconcile()
+	if cr.Status.Phase != v1alpha1.Failed || rt.eks.profiles["web"].Status != types.FargateProfileStatusActive {
+		t.Fatalf("expected the CR to fail and keep web, got phase %v and %v", cr.Status.Phase, rt.eks.profiles["web"].Status)
+	}
+	if _, acquired := rt.reconciler.ClusterLocks.TryAcquire("us-east-1/prod", testNsName("other")); !acquired {
+		t.Error("expected the cluster lock to be released")
+	}
+}
@@ -1,102 +1,161 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
-	"github.com/aws/aws-sdk-go/service/eks"
-	"github.com/aws/aws-sdk-go/service/eks/eksiface"
-	"github.com/aws/aws-sdk-go/service/iam"
-	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
 )
 
-func eksClusterExists(eksClient eksiface.EKSAPI, clusterName string) (*eks.DescribeClusterOutput, bool, error) {
+func eksClusterExists(ctx context.Context, eksClient EksAPI, clusterName string) (*eks.DescribeClusterOutput, bool, error) {
 
 	in := &eks.DescribeClusterInput{Name: aws.String(clusterName)}
-	out, err := eksClient.DescribeCluster(in)
+	out, err := eksClient.DescribeCluster(ctx, in)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == eks.ErrCodeResourceNotFoundException {
-				return nil, false, nil
-			}
+		var notFound *ekstypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, false, nil
 		}
-		return nil, false, err
+		return nil, false, typedAwsErr(err)
 	}
 
 	return out, true, nil
 }
 
-func subnetCheck(subnetsToCheck []string, vpcID string, ec2Client ec2iface.EC2API) error {
+func fProfileExists(ctx context.Context, clusterName, fargateProfileName string, eksClient EksAPI) (*ekstypes.FargateProfile, bool, error) {
+	out, err := eksClient.DescribeFargateProfile(ctx, &eks.DescribeFargateProfileInput{
+		ClusterName:        aws.String(clusterName),
+		FargateProfileName: aws.String(fargateProfileName),
+	})
+	if err != nil {
+		var notFound *ekstypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			return nil, false, nil
+		}
+		return nil, false, typedAwsErr(err)
+	}
+
+	return out.FargateProfile, true, nil
+}
+
+func subnetCheck(ctx context.Context, subnetsToCheck []string, vpcID string, ec2Client Ec2API) error {
 
-	// list route tables of that vpc for the N subnets associations
-	// aws will not complain if one of the subnet association does not exist at all
-	out, err := ec2Client.DescribeRouteTables(&ec2.DescribeRouteTablesInput{
-		Filters: []*ec2.Filter{
+	// filtering instead of passing SubnetIds, so subnets that do not exist are reported instead of failing the call
+	subnetVpcs := map[string]string{}
+	subnetPages := ec2.NewDescribeSubnetsPaginator(ec2Client, &ec2.DescribeSubnetsInput{
+		Filters: []ec2types.Filter{
 			{
-				Name:   aws.String("vpc-id"),
-				Values: aws.StringSlice([]string{vpcID}),
+				Name:   aws.String("subnet-id"),
+				Values: subnetsToCheck,
 			},
+		},
+	})
+	for subnetPages.HasMorePages() {
+		out, err := subnetPages.NextPage(ctx)
+		if err != nil {
+			return typedAwsErr(err)
+		}
+		for _, subnet := range out.Subnets {
+			subnetVpcs[aws.ToString(subnet.SubnetId)] = aws.ToString(subnet.VpcId)
+		}
+	}
+
+	// subnets without an explicit association use the main route table of the vpc, so list all of them
+	var routeTables []ec2types.RouteTable
+	routeTablePages := ec2.NewDescribeRouteTablesPaginator(ec2Client, &ec2.DescribeRouteTablesInput{
+		Filters: []ec2types.Filter{
 			{
-				Name:   aws.String("association.subnet-id"),
-				Values: aws.StringSlice(subnetsToCheck),
+				Name:   aws.String("vpc-id"),
+				Values: []string{vpcID},
 			},
 		},
 	})
-	if err != nil {
-		return err
+	for routeTablePages.HasMorePages() {
+		out, err := routeTablePages.NextPage(ctx)
+		if err != nil {
+			return typedAwsErr(err)
+		}
+		routeTables = append(routeTables, out.RouteTables...)
 	}
+	subnetRoutes, mainRoutes, hasMainRouteTable := routeTablesToSubnetIDMap(routeTables)
 
-	subnetsFoundInAws := routeTablesToSubnetIDMap(out.RouteTables)
 	for _, subnetID := range subnetsToCheck {
-		// ensure subnetID is valid and is within the same cluster VPC
-		routes, subnetFoundInAws := subnetsFoundInAws[subnetID]
+		subnetVpc, subnetFoundInAws := subnetVpcs[subnetID]
 		if !subnetFoundInAws {
-			return ErrInvalidSubnet{Message: fmt.Sprintf("Subnet %v either does not"+
-				" exist or is not associated to any route table", subnetID)}
+			return ErrInvalidSubnet{Message: fmt.Sprintf("Subnet %v does not exist", subnetID)}
+		}
+		if subnetVpc != vpcID {
+			return ErrInvalidSubnet{Message: fmt.Sprintf("Subnet %v is in %v, not in the cluster VPC %v", subnetID, subnetVpc, vpcID)}
+		}
+
+		routes, explicitlyAssociated := subnetRoutes[subnetID]
+		if !explicitlyAssociated {
+			if !hasMainRouteTable {
+				return ErrInvalidSubnet{Message: fmt.Sprintf("Subnet %v is not associated to any route table "+
+					"and %v has no main route table", subnetID, vpcID)}
+			}
+			routes = mainRoutes
 		}
 
 		if !isSubnetPrivate(routes) {
-			return ErrInvalidSubnet{Message: fmt.Sprintf("Subnet %v is not a private subnet."+
-				"EKS Fargat subnets must be private", subnetID)}
+			return ErrInvalidSubnet{Message: fmt.Sprintf("Subnet %v is not a private subnet, "+
+				"it has a default route to an internet gateway. EKS Fargate subnets must be private", subnetID)}
 		}
 	}
 	return nil
 }
 
-func iamRoleExists(roleName string, iamapi iamiface.IAMAPI) (*iam.GetRoleOutput, bool, error) {
-	out, err := iamapi.GetRole(&iam.GetRoleInput{RoleName: aws.String(roleName)})
+func iamRoleExists(ctx context.Context, roleName string, iamapi IamAPI) (*iam.GetRoleOutput, bool, error) {
+	out, err := iamapi.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)})
 	if err != nil {
-		if awsErr, isAwsErr := err.(awserr.Error); isAwsErr {
-			if awsErr.Code() == iam.ErrCodeNoSuchEntityException {
-				return nil, false, nil
-			}
+		var noSuchEntity *iamtypes.NoSuchEntityException
+		if errors.As(err, &noSuchEntity) {
+			return nil, false, nil
 		}
 		// non-recognized error
-		return nil, false, err
+		return nil, false, typedAwsErr(err)
 	}
 	return out, true, nil
 
 }
 
-func routeTablesToSubnetIDMap(rts []*ec2.RouteTable) map[string][]*ec2.Route {
-	subnetsFoundAttached := map[string][]*ec2.Route{}
+// routeTablesToSubnetIDMap returns the routes of each explicitly associated subnet and the routes of the main route table
+func routeTablesToSubnetIDMap(rts []ec2types.RouteTable) (map[string][]ec2types.Route, []ec2types.Route, bool) {
+	subnetsFoundAttached := map[string][]ec2types.Route{}
+	var mainRoutes []ec2types.Route
+	hasMain := false
 	for _, rt := range rts {
 		for _, rtA := range rt.Associations {
+			if aws.ToBool(rtA.Main) {
+				mainRoutes, hasMain = rt.Routes, true
+			}
+			if rtA.SubnetId == nil {
+				continue
+			}
 			subnetsFoundAttached[*rtA.SubnetId] = rt.Routes
 		}
 	}
-	return subnetsFoundAttached
+	return subnetsFoundAttached, mainRoutes, hasMain
 }
 
-func isSubnetPrivate(r []*ec2.Route) bool {
+// isSubnetPrivate reports whether none of the IPv4 or IPv6 default routes go straight to an internet gateway.
+// Default routes through NAT gateways, transit gateways, egress-only internet gateways or
+// network appliances keep the subnet private, blackholed routes do not route anything.
+func isSubnetPrivate(r []ec2types.Route) bool {
 	for _, rt := range r {
-		if rt.DestinationCidrBlock != nil && rt.GatewayId != nil {
-			if *rt.DestinationCidrBlock == "0.0.0.0/0" && strings.HasPrefix(*rt.GatewayId, "igw-") {
-				return false
-			}
+		if rt.State == ec2types.RouteStateBlackhole || !strings.HasPrefix(aws.ToString(rt.GatewayId), "igw-") {
+			continue
+		}
+		if aws.ToString(rt.DestinationCidrBlock) == "0.0.0.0/0" || aws.ToString(rt.DestinationIpv6CidrBlock) == "::/0" {
+			return false
 		}
 	}
 	return true
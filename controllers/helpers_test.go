@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestIsSubnetPrivate(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []ec2types.Route
+		want   bool
+	}{
+		{name: "no routes", want: true},
+		{name: "local route only", routes: []ec2types.Route{
+			{DestinationCidrBlock: aws.String("10.0.0.0/16"), GatewayId: aws.String("local")},
+		}, want: true},
+		{name: "ipv4 default route to an internet gateway", routes: []ec2types.Route{
+			{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-123")},
+		}, want: false},
+		{name: "ipv6 default route to an internet gateway", routes: []ec2types.Route{
+			{DestinationIpv6CidrBlock: aws.String("::/0"), GatewayId: aws.String("igw-123")},
+		}, want: false},
+		{name: "ipv4 default route to a nat gateway", routes: []ec2types.Route{
+			{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-123")},
+		}, want: true},
+		{name: "ipv4 default route to a transit gateway", routes: []ec2types.Route{
+			{DestinationCidrBlock: aws.String("0.0.0.0/0"), TransitGatewayId: aws.String("tgw-123")},
+		}, want: true},
+		{name: "ipv6 default route to an egress-only internet gateway", routes: []ec2types.Route{
+			{DestinationIpv6CidrBlock: aws.String("::/0"), EgressOnlyInternetGatewayId: aws.String("eigw-123")},
+		}, want: true},
+		{name: "internet gateway for a specific range only", routes: []ec2types.Route{
+			{DestinationCidrBlock: aws.String("192.168.0.0/16"), GatewayId: aws.String("igw-123")},
+		}, want: true},
+		{name: "blackholed default route to an internet gateway", routes: []ec2types.Route{
+			{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-123"), State: ec2types.RouteStateBlackhole},
+		}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSubnetPrivate(tt.routes); got != tt.want {
+				t.Errorf("isSubnetPrivate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteTablesToSubnetIDMap(t *testing.T) {
+	mainRoutes := []ec2types.Route{{DestinationCidrBlock: aws.String("0.0.0.0/0"), NatGatewayId: aws.String("nat-123")}}
+	publicRoutes := []ec2types.Route{{DestinationCidrBlock: aws.String("0.0.0.0/0"), GatewayId: aws.String("igw-123")}}
+
+	tests := []struct {
+		name        string
+		routeTables []ec2types.RouteTable
+		wantSubnets map[string][]ec2types.Route
+		wantMain    []ec2types.Route
+		wantHasMain bool
+	}{
+		{
+			name:        "no route tables",
+			wantSubnets: map[string][]ec2types.Route{},
+		},
+		{
+			name: "explicit associations and a main route table",
+			routeTables: []ec2types.RouteTable{
+				{Routes: mainRoutes, Associations: []ec2types.RouteTableAssociation{{Main: aws.Bool(true)}}},
+				{Routes: publicRoutes, Associations: []ec2types.RouteTableAssociation{
+					{SubnetId: aws.String("subnet-a")},
+					{SubnetId: aws.String("subnet-b")},
+				}},
+			},
+			wantSubnets: map[string][]ec2types.Route{"subnet-a": publicRoutes, "subnet-b": publicRoutes},
+			wantMain:    mainRoutes,
+			wantHasMain: true,
+		},
+		{
+			name: "main route table explicitly associated to a subnet",
+			routeTables: []ec2types.RouteTable{
+				{Routes: mainRoutes, Associations: []ec2types.RouteTableAssociation{
+					{Main: aws.Bool(true)},
+					{SubnetId: aws.String("subnet-a")},
+				}},
+			},
+			wantSubnets: map[string][]ec2types.Route{"subnet-a": mainRoutes},
+			wantMain:    mainRoutes,
+			wantHasMain: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnets, main, hasMain := routeTablesToSubnetIDMap(tt.routeTables)
+			if !reflect.DeepEqual(subnets, tt.wantSubnets) {
+				t.Errorf("subnet routes = %v, want %v", subnets, tt.wantSubnets)
+			}
+			if !reflect.DeepEqual(main, tt.wantMain) || hasMain != tt.wantHasMain {
+				t.Errorf("main routes = %v %v, want %v %v", main, hasMain, tt.wantMain, tt.wantHasMain)
+			}
+		})
+	}
+}
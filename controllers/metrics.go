@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "eks_fargate_controller"
+
+var (
+	profilesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "profiles",
+		Help:      "Number of fargate-profiles per phase and eks cluster",
+	}, []string{"phase", "cluster"})
+
+	profileCreateDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "profile_create_duration_seconds",
+		Help:      "Time taken by a fargate-profile to go from created to ACTIVE",
+		Buckets:   prometheus.ExponentialBuckets(30, 2, 8),
+	}, []string{"cluster"})
+
+	profileDeleteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "profile_delete_duration_seconds",
+		Help:      "Time taken from the CR deletion request until the fargate-profile is deleted",
+		Buckets:   prometheus.ExponentialBuckets(30, 2, 8),
+	}, []string{"cluster"})
+
+	profilesDisappeared = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "profiles_disappeared_total",
+		Help:      "Number of Ready fargate-profiles found deleted outside of the controller",
+	}, []string{"cluster"})
+
+	awsAPICalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "aws_api_calls_total",
+		Help:      "Number of AWS API call attempts by service, operation and error code",
+	}, []string{"service", "operation", "error_code"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(profilesGauge, profileCreateDuration, profileDeleteDuration, profilesDisappeared, awsAPICalls)
+}
+
+type profileState struct {
+	phase   v1alpha1.Phase
+	cluster string
+}
+
+// profileTracker remembers the last seen phase of every CR so the
+// per phase gauge can be rebuilt without listing every CR each time
+var profileTracker = struct {
+	sync.Mutex
+	profiles map[types.NamespacedName]profileState
+}{profiles: map[types.NamespacedName]profileState{}}
+
+func recordProfilePhase(nsName types.NamespacedName, fp *v1alpha1.FargateProfile) {
+	profileTracker.Lock()
+	defer profileTracker.Unlock()
+	profileTracker.profiles[nsName] = profileState{phase: fp.Status.Phase, cluster: fp.Spec.ClusterName}
+	refreshProfilesGauge()
+}
+
+func forgetProfile(nsName types.NamespacedName) {
+	profileTracker.Lock()
+	defer profileTracker.Unlock()
+	delete(profileTracker.profiles, nsName)
+	refreshProfilesGauge()
+}
+
+// refreshProfilesGauge must be called with profileTracker locked
+func refreshProfilesGauge() {
+	profilesGauge.Reset()
+	for _, state := range profileTracker.profiles {
+		profilesGauge.WithLabelValues(string(state.phase), state.cluster).Inc()
+	}
+}
+
+func observeCreateDuration(fp *v1alpha1.FargateProfile) {
+	if fp.Status.CreatedAt == nil {
+		return
+	}
+	profileCreateDuration.WithLabelValues(fp.Spec.ClusterName).Observe(time.Since(fp.Status.CreatedAt.Time).Seconds())
+}
+
+func observeDeleteDuration(fp *v1alpha1.FargateProfile) {
+	if fp.GetDeletionTimestamp() == nil {
+		return
+	}
+	profileDeleteDuration.WithLabelValues(fp.Spec.ClusterName).Observe(time.Since(fp.GetDeletionTimestamp().Time).Seconds())
+}
+
+// addAwsAPICallCounter is added to every aws config. It sits right after the retry middleware
+// so each attempt, including retries, gets counted
+func addAwsAPICallCounter(stack *middleware.Stack) error {
+	return stack.Finalize.Insert(middleware.FinalizeMiddlewareFunc("CountAwsAPICall", countAwsAPICall), "Retry", middleware.After)
+}
+
+func countAwsAPICall(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	out, metadata, err := next.HandleFinalize(ctx, in)
+
+	errCode := ""
+	if err != nil {
+		errCode = "Unknown"
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			errCode = apiErr.ErrorCode()
+		}
+	}
+	awsAPICalls.WithLabelValues(awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx), errCode).Inc()
+	return out, metadata, err
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// histogramCount returns how many observations the histogram has for the cluster
+func histogramCount(t *testing.T, name, cluster string) uint64 {
+	t.Helper()
+	families, errGathering := metrics.Registry.Gather()
+	if errGathering != nil {
+		t.Fatal(errGathering)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "cluster" && label.GetValue() == cluster {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestReconcileProfileMetrics(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	// a cluster of its own, the metrics are shared with the other tests
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.ClusterName = "metrics"
+	})
+	createDurations := histogramCount(t, "eks_fargate_controller_profile_create_duration_seconds", "metrics")
+	deleteDurations := histogramCount(t, "eks_fargate_controller_profile_delete_duration_seconds", "metrics")
+
+	rt.reconcile()
+	if got := testutil.ToFloat64(profilesGauge.WithLabelValues(string(v1alpha1.Creating), "metrics")); got != 1 {
+		t.Errorf("expected one Creating fargate-profile, got %v", got)
+	}
+	rt.eks.settle("web")
+	rt.reconcile()
+	if got := testutil.ToFloat64(profilesGauge.WithLabelValues(string(v1alpha1.Creating), "metrics")); got != 0 {
+		t.Errorf("expected the fargate-profile to no longer be counted as Creating, got %v", got)
+	}
+	if got := testutil.ToFloat64(profilesGauge.WithLabelValues(string(v1alpha1.Ready), "metrics")); got != 1 {
+		t.Errorf("expected one Ready fargate-profile, got %v", got)
+	}
+	if got := histogramCount(t, "eks_fargate_controller_profile_create_duration_seconds", "metrics"); got != createDurations+1 {
+		t.Errorf("expected the time to ACTIVE to be observed once, got %v observations after %v", got, createDurations)
+	}
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		now := metav1.Now()
+		cr.DeletionTimestamp = &now
+	})
+	rt.reconcile()
+	rt.eks.settle("web")
+	rt.reconcile()
+	if got := testutil.ToFloat64(profilesGauge.WithLabelValues(string(v1alpha1.Ready), "metrics")); got != 0 {
+		t.Errorf("expected the deleted fargate-profile to no longer be counted, got %v", got)
+	}
+	if got := histogramCount(t, "eks_fargate_controller_profile_delete_duration_seconds", "metrics"); got != deleteDurations+1 {
+		t.Errorf("expected the time to delete to be observed once, got %v observations after %v", got, deleteDurations)
+	}
+}
+
+type fakeHTTPClient func(*http.Request) (*http.Response, error)
+
+func (f fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestAwsAPICallCounter(t *testing.T) {
+	found := true
+	eksClient := eks.NewFromConfig(aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKIATEST", "secret", ""),
+		Retryer:     func() aws.Retryer { return aws.NopRetryer{} },
+		APIOptions:  []func(*middleware.Stack) error{addAwsAPICallCounter},
+		HTTPClient: fakeHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if found {
+				return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req,
+					Body: io.NopCloser(strings.NewReader(`{"fargateProfile":{"fargateProfileName":"web"}}`))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusNotFound, Request: req,
+				Header: http.Header{"X-Amzn-Errortype": []string{"ResourceNotFoundException"}},
+				Body:   io.NopCloser(strings.NewReader(`{"message":"not found"}`))}, nil
+		}),
+	})
+	calls := func(errCode string) float64 {
+		return testutil.ToFloat64(awsAPICalls.WithLabelValues("EKS", "DescribeFargateProfile", errCode))
+	}
+	succeeded, notFound := calls(""), calls("ResourceNotFoundException")
+	describe := func() {
+		_, _ = eksClient.DescribeFargateProfile(context.Background(), &eks.DescribeFargateProfileInput{
+			ClusterName:        aws.String("prod"),
+			FargateProfileName: aws.String("web"),
+		})
+	}
+
+	describe()
+	if got := calls(""); got != succeeded+1 {
+		t.Errorf("expected the successful call to be counted, got %v after %v", got, succeeded)
+	}
+	found = false
+	describe()
+	if got := calls("ResourceNotFoundException"); got != notFound+1 {
+		t.Errorf("expected the failed call to be counted with its error code, got %v after %v", got, notFound)
+	}
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+)
+
+// DefaultProfileNameTemplate names fargate-profiles after their CR
+const DefaultProfileNameTemplate = "{{.Name}}"
+
+// ProfileNameData is what the fargate-profile naming template is rendered with
+type ProfileNameData struct {
+	Name        string
+	Namespace   string
+	ClusterName string
+}
+
+// ParseProfileNameTemplate parses a fargate-profile naming template and makes sure it renders
+func ParseProfileNameTemplate(text string) (*template.Template, error) {
+	tmpl, errParsing := template.New("profile-name").Option("missingkey=error").Parse(text)
+	if errParsing != nil {
+		return nil, errParsing
+	}
+	var out bytes.Buffer
+	if errRendering := tmpl.Execute(&out, ProfileNameData{Name: "name", Namespace: "namespace", ClusterName: "cluster"}); errRendering != nil {
+		return nil, errRendering
+	}
+	return tmpl, nil
+}
+
+// fargateProfileName returns the name of the fargate-profile on AWS side. Once a profile was created
+// the name recorded in status is used, so changing the template does not orphan existing profiles.
+// CRs from before the name was recorded keep the CR name their profile was created with.
+func (r *FargateProfileReconciler) fargateProfileName(cr *v1alpha1.FargateProfile) (string, error) {
+	if cr.Status.FargateProfileName != "" {
+		return cr.Status.FargateProfileName, nil
+	}
+	if isLegacyCR(cr) {
+		return cr.GetName(), nil
+	}
+	return r.desiredFargateProfileName(cr)
+}
+
+// desiredFargateProfileName resolves spec.profileName or the naming template to a valid fargate-profile name
+func (r *FargateProfileReconciler) desiredFargateProfileName(cr *v1alpha1.FargateProfile) (string, error) {
+	if cr.Spec.ProfileName != "" {
+		return cr.Spec.ProfileName, nil
+	}
+	if r.ProfileNameTemplate == nil {
+		return v1alpha1.FitFargateProfileName(cr.GetName()), nil
+	}
+
+	var out bytes.Buffer
+	if errRendering := r.ProfileNameTemplate.Execute(&out, ProfileNameData{
+		Name:        cr.GetName(),
+		Namespace:   cr.GetNamespace(),
+		ClusterName: cr.Spec.ClusterName,
+	}); errRendering != nil {
+		return "", ErrInvalidProfileName{Message: fmt.Sprintf("failed to render the fargate-profile name: %v", errRendering)}
+	}
+	return v1alpha1.FitFargateProfileName(out.String()), nil
+}
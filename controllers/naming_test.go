@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+)
+
+func TestParseProfileNameTemplate(t *testing.T) {
+	for _, text := range []string{"{{.Name}}", "{{.Namespace}}-{{.Name}}", "{{.ClusterName}}-{{.Name}}"} {
+		if _, errParsing := ParseProfileNameTemplate(text); errParsing != nil {
+			t.Errorf("ParseProfileNameTemplate(%q) failed: %v", text, errParsing)
+		}
+	}
+	for _, text := range []string{"{{.Name", "{{.Owner}}"} {
+		if _, errParsing := ParseProfileNameTemplate(text); errParsing == nil {
+			t.Errorf("expected ParseProfileNameTemplate(%q) to fail", text)
+		}
+	}
+}
+
+func TestReconcileNamesProfileAfterEarlyFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		profileName string
+		want        string
+	}{
+		{name: "naming template", want: "default-web"},
+		{name: "spec.profileName", profileName: "web-profile", want: "web-profile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReconcileTest(t, "")
+			tmpl, errParsing := ParseProfileNameTemplate("{{.Namespace}}-{{.Name}}")
+			if errParsing != nil {
+				t.Fatal(errParsing)
+			}
+			rt.reconciler.ProfileNameTemplate = tmpl
+			rt.reconciler.DefaultTags = &v1alpha1.DefaultTags{
+				Tags:          map[string]string{"cost-center": "1234"},
+				ProtectedKeys: []string{"cost-center"},
+			}
+			rt.update(func(cr *v1alpha1.FargateProfile) {
+				cr.Spec.ProfileName = tt.profileName
+				cr.Spec.Tags = map[string]string{"cost-center": "42"}
+			})
+
+			if cr := rt.reconcile(); cr.Status.Phase != v1alpha1.Failed {
+				t.Fatalf("expected the protected tag override to fail the CR, got phase %v", cr.Status.Phase)
+			}
+
+			rt.update(func(cr *v1alpha1.FargateProfile) {
+				cr.Spec.Tags = map[string]string{"team": "web"}
+				cr.Generation++
+			})
+			cr := rt.reconcile()
+			if cr.Status.Phase != v1alpha1.Creating || cr.Status.FargateProfileName != tt.want {
+				t.Fatalf("expected fargate-profile %v to be created, got phase %v and name %q", tt.want, cr.Status.Phase, cr.Status.FargateProfileName)
+			}
+			if _, found := rt.eks.profiles[tt.want]; !found || len(rt.eks.profiles) != 1 {
+				t.Errorf("expected only %v on AWS side, got %v", tt.want, rt.eks.profiles)
+			}
+		})
+	}
+}
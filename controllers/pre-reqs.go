@@ -1,39 +1,60 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
-	"github.com/aws/aws-sdk-go/service/eks"
-	"github.com/aws/aws-sdk-go/service/eks/eksiface"
-	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"strings"
 )
 
-func runPreFlightChecks(eksClient eksiface.EKSAPI, ec2Client ec2iface.EC2API, iamClient iamiface.IAMAPI, cr *v1alpha1.FargateProfile) error {
+// runPreFlightChecks validates the cluster, pod execution role and subnets in that order
+// and records the outcome of each check as a condition on the CR
+func runPreFlightChecks(ctx context.Context, eksClient EksAPI, ec2Client Ec2API, iamClient IamAPI, cr *v1alpha1.FargateProfile) error {
 
-	clusterState, clusterExists, errDescribingCluster := eksClusterExists(eksClient, cr.Spec.ClusterName)
-	if errDescribingCluster != nil {
-		return errDescribingCluster
-	}
-	if !clusterExists {
-		return ErrEksClusterNotFound{Message: fmt.Sprintf("%v eks cluster not found", cr.Spec.ClusterName)}
-	}
-	if *clusterState.Cluster.Status != eks.ClusterStatusActive {
-		return ErrEksClusterNotActive{Message: fmt.Sprintf("%v eks cluster is not yet active", cr.Spec.ClusterName)}
+	clusterState, errCheckingCluster := clusterCheck(ctx, eksClient, cr.Spec.ClusterName)
+	if errCheckingCluster != nil {
+		setConditionFromErr(cr, v1alpha1.ClusterReady, errCheckingCluster)
+		return errCheckingCluster
 	}
+	setCondition(cr, v1alpha1.ClusterReady, metav1.ConditionTrue, "EksClusterActive", fmt.Sprintf("%v eks cluster is active", cr.Spec.ClusterName))
 
 	roleName := func(arn string) string {
 		temp := strings.SplitAfter(arn, "/")
 		return temp[(len(temp) - 1)]
 	}(cr.Spec.PodExecutionRoleArn)
-	_, roleExists, errDescribingRole := iamRoleExists(roleName, iamClient)
+	_, roleExists, errDescribingRole := iamRoleExists(ctx, roleName, iamClient)
 	if errDescribingRole != nil {
+		setConditionFromErr(cr, v1alpha1.RoleValid, errDescribingRole)
 		return errDescribingRole
 	}
 	if !roleExists {
-		return ErrPodExecutionRoleArnNotFound{Message: fmt.Sprintf("%v: role name not found", roleName)}
+		errRoleNotFound := ErrPodExecutionRoleArnNotFound{Message: fmt.Sprintf("%v: role name not found", roleName)}
+		setConditionFromErr(cr, v1alpha1.RoleValid, errRoleNotFound)
+		return errRoleNotFound
+	}
+	setCondition(cr, v1alpha1.RoleValid, metav1.ConditionTrue, "PodExecutionRoleFound", fmt.Sprintf("%v role exists", roleName))
+
+	if errCheckingSubnets := subnetCheck(ctx, cr.Spec.Subnets, *clusterState.Cluster.ResourcesVpcConfig.VpcId, ec2Client); errCheckingSubnets != nil {
+		setConditionFromErr(cr, v1alpha1.SubnetsValid, errCheckingSubnets)
+		return errCheckingSubnets
 	}
+	setCondition(cr, v1alpha1.SubnetsValid, metav1.ConditionTrue, "SubnetsPrivate", "All subnets are private and within the cluster VPC")
+	return nil
+}
 
-	return subnetCheck(cr.Spec.Subnets, *clusterState.Cluster.ResourcesVpcConfig.VpcId, ec2Client)
+func clusterCheck(ctx context.Context, eksClient EksAPI, clusterName string) (*eks.DescribeClusterOutput, error) {
+	clusterState, clusterExists, errDescribingCluster := eksClusterExists(ctx, eksClient, clusterName)
+	if errDescribingCluster != nil {
+		return nil, errDescribingCluster
+	}
+	if !clusterExists {
+		return nil, ErrEksClusterNotFound{Message: fmt.Sprintf("%v eks cluster not found", clusterName)}
+	}
+	if clusterState.Cluster.Status != types.ClusterStatusActive {
+		return nil, ErrEksClusterNotActive{Message: fmt.Sprintf("%v eks cluster is not yet active", clusterName)}
+	}
+	return clusterState, nil
 }
@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// fProfileNeedsReplacement reports whether the immutable parts of the fargate-profile on AWS
+// ( selectors, subnets and pod execution role ) have drifted away from the desired create input.
+// EKS does not allow updating any of these in place, so a drift means the profile must be recreated.
+func fProfileNeedsReplacement(desired *eks.CreateFargateProfileInput, current *types.FargateProfile) bool {
+	if aws.ToString(desired.PodExecutionRoleArn) != aws.ToString(current.PodExecutionRoleArn) {
+		return true
+	}
+	if !v1alpha1.EqualStringSets(desired.Subnets, current.Subnets) {
+		return true
+	}
+	return !v1alpha1.EqualStringSets(selectorKeys(desired.Selectors), selectorKeys(current.Selectors))
+}
+
+// replaceBlueGreen creates a suffixed fargate-profile from the current spec, waits for it to go ACTIVE
+// and only then deletes the old profile, so pods matching the selectors can always be scheduled.
+func (r *FargateProfileReconciler) replaceBlueGreen(ctx context.Context, cr *v1alpha1.FargateProfile, currentName string, eksClient EksAPI) (ctrl.Result, error) {
+	crName := fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName())
+	// the profile replaced by an earlier rollout still blocks creates on the cluster
+	if result, deleted, errWaiting := r.waitForReplacedFProfile(ctx, cr, eksClient); !deleted {
+		return result, errWaiting
+	}
+	newName := cr.Status.PendingFargateProfileName
+	var newFp *types.FargateProfile
+	newFpExists := false
+	if newName != "" {
+		var errDescribingNewFp error
+		newFp, newFpExists, errDescribingNewFp = fProfileExists(ctx, cr.Spec.ClusterName, newName, eksClient)
+		if errDescribingNewFp != nil {
+			r.Log.Error(errDescribingNewFp, fmt.Sprintf("Failed to describe fargate-profile %v", newName))
+			return ctrl.Result{}, errDescribingNewFp
+		}
+		// the spec changed again while the rollout was in flight, its profile would never match it and be orphaned otherwise
+		if !newFpExists || !isManagedFProfile(cr, newFp, r.ControllerID) ||
+			fProfileNeedsReplacement(cr.WithCreateIn(newName, r.ControllerID, r.DefaultTags), newFp) {
+			if result, cleanedUp, errCleaningUp := r.cleanUpPendingFProfile(ctx, cr, eksClient); !cleanedUp {
+				return result, errCleaningUp
+			}
+			newName = ""
+		}
+	}
+
+	if newName == "" {
+		baseName, errNamingFp := r.desiredFargateProfileName(cr)
+		if errNamingFp != nil {
+			return ctrl.Result{}, errNamingFp
+		}
+		var revision int64
+		newName, revision = nextBlueGreenName(cr, baseName, currentName)
+
+		var errDescribingNewFp error
+		newFp, newFpExists, errDescribingNewFp = fProfileExists(ctx, cr.Spec.ClusterName, newName, eksClient)
+		if errDescribingNewFp != nil {
+			r.Log.Error(errDescribingNewFp, fmt.Sprintf("Failed to describe fargate-profile %v", newName))
+			return ctrl.Result{}, errDescribingNewFp
+		}
+		if newFpExists && !isManagedFProfile(cr, newFp, r.ControllerID) {
+			errNotOwned := ErrFargateProfileNotOwned{Message: fmt.Sprintf("cannot replace %v, "+
+				"fargate-profile %v already exists and is not owned by this CR", currentName, newName)}
+			r.Log.Info(fmt.Sprintf("%s: %v", crName, errNotOwned.Message))
+			r.Recorder.Event(cr, corev1.EventTypeWarning, errNotOwned.Reason(), errNotOwned.Error())
+			setConditionFromErr(cr, v1alpha1.Synced, errNotOwned)
+			updateCrPhase(v1alpha1.Failed, cr)
+			return ctrl.Result{}, nil
+		}
+		cr.Status.BlueGreenRevision = revision
+		// created by this rollout before its status could be saved, check it against the spec on the next pass
+		if newFpExists {
+			cr.Status.PendingFargateProfileName = newName
+			return ctrl.Result{Requeue: true}, nil
+		}
+
+		if !r.acquireClusterLock(cr) {
+			return ctrl.Result{RequeueAfter: clusterLockRetryInterval}, nil
+		}
+		cr.Status.PendingFargateProfileName = newName
+		if _, errCreatingFProfile := createFProfile(ctx, cr.WithCreateIn(newName, r.ControllerID, r.DefaultTags), eksClient); errCreatingFProfile != nil {
+			r.Log.Error(errCreatingFProfile, fmt.Sprintf("Failed to create fargate-profile %v", newName))
+			r.releaseClusterLock(clusterLockOwner(cr))
+			return ctrl.Result{}, errCreatingFProfile
+		}
+		r.Log.Info(fmt.Sprintf("%s: creating fargate-profile %v to replace %v", crName, newName, currentName))
+		r.Recorder.Event(cr, corev1.EventTypeNormal, "Replacing", fmt.Sprintf("Creating fargate-profile %v to replace %v", newName, currentName))
+		updateCrPhase(v1alpha1.Replacing, cr)
+		return ctrl.Result{RequeueAfter: time.Minute, Requeue: true}, nil
+	}
+
+	newFpStatus := newFp.Status
+	if newFpStatus == types.FargateProfileStatusCreateFailed {
+		r.releaseClusterLock(clusterLockOwner(cr))
+		r.Log.Info(fmt.Sprintf("%s: fargate-profile %v failed to create, keeping %v", crName, newName, currentName))
+		r.Recorder.Event(cr, corev1.EventTypeWarning, "CreateFailed", fmt.Sprintf("fargate-profile %v failed to create, keeping %v", newName, currentName))
+		setCondition(cr, v1alpha1.Synced, metav1.ConditionFalse, "CreateFailed", fmt.Sprintf("%v fargate-profile failed to create", newName))
+		updateCrPhase(v1alpha1.Failed, cr)
+		return ctrl.Result{}, nil
+	}
+	if newFpStatus != types.FargateProfileStatusActive {
+		r.Log.Info(fmt.Sprintf("%s: fargate-profile %v is not active yet. Current status: %v", crName, newName, newFpStatus))
+		updateCrPhase(v1alpha1.Replacing, cr)
+		return ctrl.Result{RequeueAfter: time.Minute, Requeue: true}, nil
+	}
+
+	// new profile is serving pods now, the old one can go away
+	if !r.acquireClusterLock(cr) {
+		return ctrl.Result{RequeueAfter: clusterLockRetryInterval}, nil
+	}
+	if errDeletingFprofile := deleteFprofile(ctx, cr.WithDeleteIn(currentName), eksClient); errDeletingFprofile != nil {
+		r.Log.Error(errDeletingFprofile, fmt.Sprintf("Failed to delete fargate-profile %v", currentName))
+		r.releaseClusterLock(clusterLockOwner(cr))
+		return ctrl.Result{}, errDeletingFprofile
+	}
+	r.Log.Info(fmt.Sprintf("%s: fargate-profile %v replaced by %v", crName, currentName, newName))
+	r.Recorder.Event(cr, corev1.EventTypeNormal, "Replaced", fmt.Sprintf("fargate-profile %v replaced by %v", currentName, newName))
+
+	cr.Status.FargateProfileName = newName
+	cr.Status.PendingFargateProfileName = ""
+	cr.Status.ReplacedFargateProfileName = currentName
+	cr.Status.LastAppliedSpecHash = specHash(cr.Spec)
+	setAwsStatus(cr, newFp)
+	cr.Status.Phase = v1alpha1.Replacing
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// nextBlueGreenName returns the name and revision of the fargate-profile the next blue/green rollout creates.
+// Rollouts are counted in status instead of being named after the generation, so healing drift,
+// which does not change the generation, gets a name of its own too.
+func nextBlueGreenName(cr *v1alpha1.FargateProfile, baseName, currentName string) (string, int64) {
+	revision := cr.Status.BlueGreenRevision + 1
+	name := cr.BlueGreenFargateProfileName(baseName, revision)
+	for name == currentName {
+		revision++
+		name = cr.BlueGreenFargateProfileName(baseName, revision)
+	}
+	return name, revision
+}
+
+// cleanUpPendingFProfile deletes the fargate-profile of a blue/green rollout that will not finish, because the
+// spec changed again or the CR is being deleted. It returns cleanedUp=true once the profile is gone from AWS.
+func (r *FargateProfileReconciler) cleanUpPendingFProfile(ctx context.Context, cr *v1alpha1.FargateProfile, eksClient EksAPI) (ctrl.Result, bool, error) {
+	crName := fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName())
+	pendingName := cr.Status.PendingFargateProfileName
+	if pendingName == "" {
+		return ctrl.Result{}, true, nil
+	}
+
+	pendingFp, pendingFpExists, errDescribingFp := fProfileExists(ctx, cr.Spec.ClusterName, pendingName, eksClient)
+	if errDescribingFp != nil {
+		r.Log.Error(errDescribingFp, fmt.Sprintf("Failed to describe fargate-profile %v", pendingName))
+		return ctrl.Result{}, false, errDescribingFp
+	}
+	if !pendingFpExists || !isManagedFProfile(cr, pendingFp, r.ControllerID) {
+		cr.Status.PendingFargateProfileName = ""
+		return ctrl.Result{}, true, nil
+	}
+
+	// EKS cannot delete a profile that is still being created, and one being deleted only needs waiting for
+	if pendingFp.Status == types.FargateProfileStatusCreating || pendingFp.Status == types.FargateProfileStatusDeleting {
+		r.Log.Info(fmt.Sprintf("%s: waiting for fargate-profile %v of an abandoned rollout to settle. Current status: %v", crName, pendingName, pendingFp.Status))
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, false, nil
+	}
+	if !r.acquireClusterLock(cr) {
+		return ctrl.Result{RequeueAfter: clusterLockRetryInterval}, false, nil
+	}
+	if errDeletingFprofile := deleteFprofile(ctx, cr.WithDeleteIn(pendingName), eksClient); errDeletingFprofile != nil {
+		r.Log.Error(errDeletingFprofile, fmt.Sprintf("Failed to delete fargate-profile %v", pendingName))
+		r.releaseClusterLock(clusterLockOwner(cr))
+		return ctrl.Result{}, false, errDeletingFprofile
+	}
+	r.Log.Info(fmt.Sprintf("%s: deleting fargate-profile %v of an abandoned rollout", crName, pendingName))
+	r.Recorder.Event(cr, corev1.EventTypeNormal, "Deleting", fmt.Sprintf("Deleting fargate-profile %v of an abandoned rollout", pendingName))
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, false, nil
+}
+
+// waitForReplacedFProfile waits for the fargate-profile replaced by a blue/green rollout to be deleted. The cluster lock
+// is kept meanwhile, as EKS rejects creates and deletes of other profiles of the cluster while one is DELETING.
+// It returns deleted=true once the profile is gone from AWS.
+func (r *FargateProfileReconciler) waitForReplacedFProfile(ctx context.Context, cr *v1alpha1.FargateProfile, eksClient EksAPI) (ctrl.Result, bool, error) {
+	crName := fmt.Sprintf("%s/%s", cr.GetNamespace(), cr.GetName())
+	replacedName := cr.Status.ReplacedFargateProfileName
+	if replacedName == "" {
+		return ctrl.Result{}, true, nil
+	}
+
+	replacedFp, replacedFpExists, errDescribingFp := fProfileExists(ctx, cr.Spec.ClusterName, replacedName, eksClient)
+	if errDescribingFp != nil {
+		r.Log.Error(errDescribingFp, fmt.Sprintf("Failed to describe fargate-profile %v", replacedName))
+		return ctrl.Result{}, false, errDescribingFp
+	}
+	if !replacedFpExists || !isManagedFProfile(cr, replacedFp, r.ControllerID) {
+		cr.Status.ReplacedFargateProfileName = ""
+		return ctrl.Result{}, true, nil
+	}
+
+	// the delete did not go through, e.g. the profile went DELETE_FAILED, try again
+	if replacedFp.Status != types.FargateProfileStatusDeleting {
+		if !r.acquireClusterLock(cr) {
+			return ctrl.Result{RequeueAfter: clusterLockRetryInterval}, false, nil
+		}
+		if errDeletingFprofile := deleteFprofile(ctx, cr.WithDeleteIn(replacedName), eksClient); errDeletingFprofile != nil {
+			r.Log.Error(errDeletingFprofile, fmt.Sprintf("Failed to delete fargate-profile %v", replacedName))
+			r.releaseClusterLock(clusterLockOwner(cr))
+			return ctrl.Result{}, false, errDeletingFprofile
+		}
+	}
+	r.Log.Info(fmt.Sprintf("%s: waiting for replaced fargate-profile %v to be deleted. Current status: %v", crName, replacedName, replacedFp.Status))
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, false, nil
+}
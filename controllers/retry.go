@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RetryConfig bounds how often AWS calls are retried inside the SDK and
+// how long a reconcile waits before trying again after a retryable AWS error
+type RetryConfig struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	// Jitter is the fraction, between 0 and 1, by which requeue delays are randomized
+	Jitter float64
+}
+
+func (c RetryConfig) sdkRetryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = c.MaxRetries + 1
+		o.MaxBackoff = c.MaxDelay
+		o.Backoff = retry.BackoffDelayerFunc(func(attempt int, _ error) (time.Duration, error) {
+			return c.delay(attempt - 1), nil
+		})
+	})
+}
+
+// delay returns the jittered exponential delay after the given number of failures
+func (c RetryConfig) delay(failures int) time.Duration {
+	delay := float64(c.BaseDelay) * math.Pow(2, float64(failures))
+	if delay > float64(c.MaxDelay) {
+		delay = float64(c.MaxDelay)
+	}
+	delay += delay * c.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(delay)
+}
+
+// isRetryableAwsErr reports whether the SDK gave up on an error that is worth trying again later
+func isRetryableAwsErr(err error) bool {
+	// only look at errors returned by an aws operation, typed errors from this package are never retried
+	var opErr *smithy.OperationError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// RequeueBackoff hands out exponentially growing requeue delays per FargateProfile
+type RequeueBackoff struct {
+	cfg      RetryConfig
+	mu       sync.Mutex
+	failures map[types.NamespacedName]int
+}
+
+func NewRequeueBackoff(cfg RetryConfig) *RequeueBackoff {
+	return &RequeueBackoff{cfg: cfg, failures: map[types.NamespacedName]int{}}
+}
+
+// Next records another failure for the FargateProfile and returns how long to wait before the next attempt
+func (b *RequeueBackoff) Next(nsName types.NamespacedName) time.Duration {
+	b.mu.Lock()
+	failures := b.failures[nsName]
+	b.failures[nsName] = failures + 1
+	b.mu.Unlock()
+
+	return b.cfg.delay(failures)
+}
+
+// Forget resets the backoff of the FargateProfile once a reconcile goes through
+func (b *RequeueBackoff) Forget(nsName types.NamespacedName) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.failures, nsName)
+}
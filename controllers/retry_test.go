@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestRetryConfigDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      RetryConfig
+		failures int
+		min, max time.Duration
+	}{
+		{name: "first failure", cfg: RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute}, failures: 0, min: time.Second, max: time.Second},
+		{name: "grows exponentially", cfg: RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute}, failures: 3, min: 8 * time.Second, max: 8 * time.Second},
+		{name: "capped at max delay", cfg: RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute}, failures: 10, min: time.Minute, max: time.Minute},
+		{name: "no overflow after many failures", cfg: RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute}, failures: 5000, min: time.Minute, max: time.Minute},
+		{name: "jitter stays within bounds", cfg: RetryConfig{BaseDelay: 10 * time.Second, MaxDelay: time.Minute, Jitter: 0.2}, failures: 0, min: 8 * time.Second, max: 12 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if got := tt.cfg.delay(tt.failures); got < tt.min || got > tt.max {
+					t.Fatalf("delay(%d) = %v, want between %v and %v", tt.failures, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRequeueBackoff(t *testing.T) {
+	backoff := NewRequeueBackoff(RetryConfig{BaseDelay: time.Second, MaxDelay: time.Minute})
+	nsName := testNsName("web")
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := backoff.Next(nsName); got != want {
+			t.Errorf("Next() = %v, want %v", got, want)
+		}
+	}
+	backoff.Forget(nsName)
+	if got := backoff.Next(nsName); got != time.Second {
+		t.Errorf("Next() after Forget() = %v, want %v", got, time.Second)
+	}
+}
+
+// createFailure wraps err the way the sdk returns it once it gave up on CreateFargateProfile
+func createFailure(err error) error {
+	return &smithy.OperationError{ServiceID: "EKS", OperationName: "CreateFargateProfile", Err: err}
+}
+
+func TestReconcileBacksOffRetryableAwsErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "throttling", err: createFailure(&smithy.GenericAPIError{Code: "ThrottlingException", Message: "slow down"})},
+		{name: "server error", err: createFailure(&awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}},
+			Err:      errors.New("service unavailable"),
+		}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReconcileTest(t, "")
+			rt.eks.createErr = tt.err
+
+			// the error is swallowed and the requeue delay grows with every failure
+			for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+				if result, _ := rt.reconcileResult(); result != (ctrl.Result{RequeueAfter: want}) {
+					t.Fatalf("expected a requeue after %v, got %+v", want, result)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileReturnsNonRetryableAwsErr(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.eks.createErr = createFailure(&smithy.GenericAPIError{Code: "ServerException", Message: "boom"})
+
+	result, err := rt.reconciler.Reconcile(ctrl.Request{NamespacedName: rt.nsName})
+	if err == nil || result != (ctrl.Result{}) {
+		t.Fatalf("expected the error to be returned without a requeue delay, got %+v and %v", result, err)
+	}
+	if got := rt.reconciler.Backoff.Next(rt.nsName); got != time.Second {
+		t.Errorf("expected the error not to be backed off, got the next delay %v", got)
+	}
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setAwsStatus copies what AWS reports about the fargate-profile into the CR status
+func setAwsStatus(cr *v1alpha1.FargateProfile, fp *types.FargateProfile) {
+	cr.Status.FargateProfileArn = aws.ToString(fp.FargateProfileArn)
+	cr.Status.AwsStatus = string(fp.Status)
+	cr.Status.Subnets = fp.Subnets
+	cr.Status.Tags = fp.Tags
+
+	cr.Status.CreatedAt = nil
+	if fp.CreatedAt != nil {
+		// status only keeps second precision, truncate so unchanged profiles do not cause status updates
+		createdAt := metav1.NewTime(fp.CreatedAt.Truncate(time.Second))
+		cr.Status.CreatedAt = &createdAt
+	}
+
+	cr.Status.Selectors = specSelectors(fp.Selectors)
+}
+
+// specSelectors converts the selectors of a fargate-profile on AWS side to their spec counterpart
+func specSelectors(selectors []types.FargateProfileSelector) []v1alpha1.FargateProfileSelector {
+	var out []v1alpha1.FargateProfileSelector
+	for _, s := range selectors {
+		// AWS returns no labels for namespace-only selectors, which the CRD schema would reject as null
+		labels := s.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		out = append(out, v1alpha1.FargateProfileSelector{
+			Labels:    labels,
+			Namespace: aws.ToString(s.Namespace),
+		})
+	}
+	return out
+}
+
+// selectorKeys flattens the selectors of a fargate-profile on AWS side like the spec ones, so both can be diffed
+func selectorKeys(selectors []types.FargateProfileSelector) []string {
+	return v1alpha1.SelectorKeys(specSelectors(selectors))
+}
+
+// specHash returns a short sha256 of the spec, used to tell which spec the fargate-profile was created from
+func specHash(spec v1alpha1.FargateProfileSpec) string {
+	// marshalling a struct of strings, slices and maps cannot fail
+	specBytes, _ := json.Marshal(spec)
+	return fmt.Sprintf("%x", sha256.Sum256(specBytes))[:16]
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// awsReservedTagPrefix marks tags AWS manages itself, they cannot be changed or removed
+const awsReservedTagPrefix = "aws:"
+
+// ParseDefaultTags parses controller default tags given as key=value pairs separated by commas
+func ParseDefaultTags(text string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		keyValue := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 || key == "" {
+			return nil, fmt.Errorf("%q is not a key=value pair", pair)
+		}
+		if strings.HasPrefix(key, v1alpha1.ManagedTagPrefix) || strings.HasPrefix(key, awsReservedTagPrefix) {
+			return nil, fmt.Errorf("tag key %v is reserved", key)
+		}
+		tags[key] = strings.TrimSpace(keyValue[1])
+	}
+	return tags, nil
+}
+
+// tagsDiff returns the tags to set and the tag keys to remove so current matches desired
+func tagsDiff(desired, current map[string]string) (map[string]string, []string) {
+	toSet := map[string]string{}
+	for key, value := range desired {
+		if currentValue, found := current[key]; !found || currentValue != value {
+			toSet[key] = value
+		}
+	}
+	var toRemove []string
+	for key := range current {
+		if _, found := desired[key]; !found && !strings.HasPrefix(key, awsReservedTagPrefix) {
+			toRemove = append(toRemove, key)
+		}
+	}
+	sort.Strings(toRemove)
+	return toSet, toRemove
+}
+
+// reconcileTags brings the tags of the fargate-profile on AWS side back to spec.tags plus the managed tags.
+// Tags are the one property EKS updates in place, so drift is corrected without replacing the profile.
+func (r *FargateProfileReconciler) reconcileTags(ctx context.Context, cr *v1alpha1.FargateProfile, fp *types.FargateProfile, eksClient EksAPI) error {
+	fpName := aws.ToString(fp.FargateProfileName)
+	desired := cr.DesiredTags(r.ControllerID, r.DefaultTags)
+	toSet, toRemove := tagsDiff(desired, fp.Tags)
+	if len(toSet) == 0 && len(toRemove) == 0 {
+		cr.Status.TagDrift = nil
+		setCondition(cr, v1alpha1.TagsSynced, metav1.ConditionTrue, "InSync", fmt.Sprintf("%v fargate-profile tags match spec", fpName))
+		return nil
+	}
+
+	var drifted []string
+	for key := range toSet {
+		drifted = append(drifted, key)
+	}
+	drifted = append(drifted, toRemove...)
+	sort.Strings(drifted)
+	// an edit of spec.tags is applied like any other spec change, only changes made on AWS side are drift
+	outOfBand := isOutOfBandDrift(cr)
+	if outOfBand {
+		cr.Status.TagDrift = drifted
+		setCondition(cr, v1alpha1.TagsSynced, metav1.ConditionFalse, "Drifted",
+			fmt.Sprintf("%v fargate-profile tags drifted from spec: %v", fpName, strings.Join(drifted, ", ")))
+	} else {
+		cr.Status.TagDrift = nil
+	}
+
+	if len(toSet) > 0 {
+		if _, errTagging := eksClient.TagResource(ctx, &eks.TagResourceInput{
+			ResourceArn: fp.FargateProfileArn,
+			Tags:        toSet,
+		}); errTagging != nil {
+			r.Log.Error(errTagging, fmt.Sprintf("Failed to tag fargate-profile %v", fpName))
+			return typedAwsErr(errTagging)
+		}
+	}
+	if len(toRemove) > 0 {
+		if _, errUntagging := eksClient.UntagResource(ctx, &eks.UntagResourceInput{
+			ResourceArn: fp.FargateProfileArn,
+			TagKeys:     toRemove,
+		}); errUntagging != nil {
+			r.Log.Error(errUntagging, fmt.Sprintf("Failed to untag fargate-profile %v", fpName))
+			return typedAwsErr(errUntagging)
+		}
+	}
+
+	tags := map[string]string{}
+	for key, value := range fp.Tags {
+		if strings.HasPrefix(key, awsReservedTagPrefix) {
+			tags[key] = value
+		}
+	}
+	for key, value := range desired {
+		tags[key] = value
+	}
+	cr.Status.Tags = tags
+	if !outOfBand {
+		setCondition(cr, v1alpha1.TagsSynced, metav1.ConditionTrue, "InSync", fmt.Sprintf("%v fargate-profile tags match spec", fpName))
+		r.Log.Info(fmt.Sprintf("%s/%s: updated tags of fargate-profile %v to match spec: %v", cr.GetNamespace(), cr.GetName(), fpName, strings.Join(drifted, ", ")))
+		return nil
+	}
+	setCondition(cr, v1alpha1.TagsSynced, metav1.ConditionTrue, "DriftCorrected",
+		fmt.Sprintf("%v fargate-profile tags were updated to match spec: %v", fpName, strings.Join(drifted, ", ")))
+	r.Log.Info(fmt.Sprintf("%s/%s: updated drifted tags of fargate-profile %v: %v", cr.GetNamespace(), cr.GetName(), fpName, strings.Join(drifted, ", ")))
+	r.Recorder.Event(cr, corev1.EventTypeNormal, "TagsUpdated", fmt.Sprintf("Updated drifted tags of fargate-profile %v: %v", fpName, strings.Join(drifted, ", ")))
+	return nil
+}
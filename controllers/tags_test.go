@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTagsDiff(t *testing.T) {
+	tests := []struct {
+		name       string
+		desired    map[string]string
+		current    map[string]string
+		wantSet    map[string]string
+		wantRemove []string
+	}{
+		{
+			name:    "in sync",
+			desired: map[string]string{"team": "web"},
+			current: map[string]string{"team": "web"},
+			wantSet: map[string]string{},
+		},
+		{
+			name:    "missing and changed tags are set",
+			desired: map[string]string{"team": "web", "env": "prod"},
+			current: map[string]string{"team": "data"},
+			wantSet: map[string]string{"team": "web", "env": "prod"},
+		},
+		{
+			name:       "extra tags are removed",
+			desired:    map[string]string{"team": "web"},
+			current:    map[string]string{"team": "web", "owner": "me", "env": "dev"},
+			wantSet:    map[string]string{},
+			wantRemove: []string{"env", "owner"},
+		},
+		{
+			name:    "aws tags are left alone",
+			desired: map[string]string{},
+			current: map[string]string{"aws:cloudformation:stack-name": "stack"},
+			wantSet: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toSet, toRemove := tagsDiff(tt.desired, tt.current)
+			if !reflect.DeepEqual(toSet, tt.wantSet) {
+				t.Errorf("tags to set = %v, want %v", toSet, tt.wantSet)
+			}
+			if !reflect.DeepEqual(toRemove, tt.wantRemove) {
+				t.Errorf("tags to remove = %v, want %v", toRemove, tt.wantRemove)
+			}
+		})
+	}
+}
+
+func TestParseDefaultTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: map[string]string{}},
+		{name: "pairs", in: "cost-center=1234, owner=platform,", want: map[string]string{"cost-center": "1234", "owner": "platform"}},
+		{name: "empty value", in: "owner=", want: map[string]string{"owner": ""}},
+		{name: "value with equal sign", in: "expr=a=b", want: map[string]string{"expr": "a=b"}},
+		{name: "missing value", in: "owner", wantErr: true},
+		{name: "missing key", in: "=platform", wantErr: true},
+		{name: "aws prefix", in: "aws:owner=platform", wantErr: true},
+		{name: "managed prefix", in: "eks-fargate-controller/owner=platform", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDefaultTags(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDefaultTags(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDefaultTags(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileTagsWithoutSpecChange(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+
+	// tags changed in the console are reset by the next audit, the spec and its generation stay the same
+	fp := rt.eks.profiles["web"]
+	fp.Tags["team"] = "data"
+	fp.Tags["added-in-console"] = "yes"
+	result, cr := rt.reconcileResult()
+
+	if fp.Tags["team"] != "web" || fp.Tags["added-in-console"] != "" {
+		t.Errorf("expected the tags to be reset to spec, got %v", fp.Tags)
+	}
+	if !reflect.DeepEqual(cr.Status.TagDrift, []string{"added-in-console", "team"}) {
+		t.Errorf("expected the drifted tag keys in status, got %v", cr.Status.TagDrift)
+	}
+	if cr.Status.Phase != v1alpha1.Ready || fp.Status != types.FargateProfileStatusActive {
+		t.Errorf("expected the fargate-profile to be updated in place, got %v and phase %v", fp.Status, cr.Status.Phase)
+	}
+	if result.RequeueAfter != testAuditInterval {
+		t.Errorf("expected the CR to keep being audited, got %+v", result)
+	}
+}
+
+func TestReconcileSpecTagsChangeIsNoDrift(t *testing.T) {
+	rt := newReconcileTest(t, "")
+	rt.createReady()
+	rt.events()
+
+	rt.update(func(cr *v1alpha1.FargateProfile) {
+		cr.Spec.Tags = map[string]string{"team": "data"}
+		cr.Generation++
+	})
+	cr := rt.reconcile()
+	if rt.eks.profiles["web"].Tags["team"] != "data" {
+		t.Fatalf("expected the tags to follow the spec, got %v", rt.eks.profiles["web"].Tags)
+	}
+	if events := rt.events(); len(cr.Status.TagDrift) != 0 || hasEvent(events, corev1.EventTypeNormal, "TagsUpdated") {
+		t.Errorf("expected the spec change not to be reported as drift, got %v and events %v", cr.Status.TagDrift, events)
+	}
+}
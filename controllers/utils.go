@@ -3,32 +3,65 @@ package controllers
 import (
 	"context"
 	"github.com/agill17/eks-fargate-controller/api/v1alpha1"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
-	"github.com/aws/aws-sdk-go/service/eks"
-	"github.com/aws/aws-sdk-go/service/eks/eksiface"
-	"github.com/aws/aws-sdk-go/service/iam"
-	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
-	"math"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func newAwsSession(region string) *session.Session {
-	sess, _ := session.NewSession(&aws.Config{
-		CredentialsChainVerboseErrors: aws.Bool(true),
-		Region:                        aws.String(region),
-		MaxRetries:                    aws.Int(math.MaxInt64),
-	})
-	return sess
+// AwsSessionConfig is everything needed to build the aws clients for a FargateProfile
+type AwsSessionConfig struct {
+	Region        string
+	AssumeRoleArn string
+	ExternalID    string
+
+	// static credentials loaded from the credentialsSecretRef along with the namespace/name of that secret,
+	// the ambient credential chain is used when empty
+	CredentialsSource string
+	AccessKeyID       string
+	SecretAccessKey   string
+	SessionToken      string
+}
+
+func NewAwsSessionConfig(fp *v1alpha1.FargateProfile) AwsSessionConfig {
+	return AwsSessionConfig{
+		Region:        fp.Spec.Region,
+		AssumeRoleArn: fp.Spec.AssumeRoleArn,
+		ExternalID:    fp.Spec.AssumeRoleExternalID,
+	}
 }
 
-func NewEksClient(region string) eksiface.EKSAPI { return eks.New(newAwsSession(region)) }
-func NewEc2Client(region string) ec2iface.EC2API { return ec2.New(newAwsSession(region)) }
-func NewIamClient(region string) iamiface.IAMAPI { return iam.New(newAwsSession(region)) }
+func newAwsConfig(ctx context.Context, cfg AwsSessionConfig, retry RetryConfig) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(cfg.Region),
+		config.WithRetryer(retry.sdkRetryer),
+		config.WithAPIOptions([]func(*middleware.Stack) error{addAwsAPICallCounter}),
+	}
+	if cfg.AccessKeyID != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken)))
+	}
+	awsCfg, errLoadingConfig := config.LoadDefaultConfig(ctx, opts...)
+	if errLoadingConfig != nil {
+		return aws.Config{}, errLoadingConfig
+	}
+
+	// the base credentials are only used to assume the role, every other call is made as the role
+	if cfg.AssumeRoleArn != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.AssumeRoleArn, func(o *stscreds.AssumeRoleOptions) {
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+		})
+		awsCfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return awsCfg, nil
+}
 
 func AddFinalizer(finalizer string, runtimeObj runtime.Object, client client.Client) error {
 	metaObj, err := meta.Accessor(runtimeObj)
@@ -74,17 +107,17 @@ func ListContainsString(lookup string, list []string) (int, bool) {
 	return -1, false
 }
 
-func updateCrPhase(phase v1alpha1.Phase, client client.Client, fp *v1alpha1.FargateProfile) error {
+// updateCrPhase only changes the phase in memory, Reconcile writes the whole status once it is done
+func updateCrPhase(phase v1alpha1.Phase, fp *v1alpha1.FargateProfile) {
+	fp.Status.Phase = phase
+}
 
-	// do not try to update if fp has a deletion timestamp
-	if fp.GetDeletionTimestamp() != nil {
-		return nil
-	}
+func updateCrStatus(client client.Client, fp *v1alpha1.FargateProfile) error {
 
-	if fp.Status.Phase != phase {
-		fp.Status.Phase = phase
-		return client.Status().Update(context.TODO(), fp)
+	// do not try to update once the finalizer is released, the CR may already be gone
+	if fp.GetDeletionTimestamp() != nil && len(fp.GetFinalizers()) == 0 {
+		return nil
 	}
 
-	return nil
+	return client.Status().Update(context.TODO(), fp)
 }
@@ -17,8 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -47,10 +50,55 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var defaultRegion string
+	var defaultClusterName string
+	var detectDefaults bool
+	var awsRetry controllers.RetryConfig
+	var reconcileTimeout time.Duration
+	var auditInterval time.Duration
+	var controllerID string
+	var profileNameTemplate string
+	var defaultDeletionPolicy string
+	var defaultTags string
+	var defaultTagsPrecedence string
+	var protectedTagKeys string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&defaultRegion, "default-region", "",
+		"The region used for FargateProfiles without spec.region.")
+	flag.StringVar(&defaultClusterName, "default-cluster-name", "",
+		"The eks cluster name used for FargateProfiles without spec.clusterName.")
+	flag.BoolVar(&detectDefaults, "detect-defaults", false,
+		"Detect --default-region and --default-cluster-name from the EC2 instance metadata and tags "+
+			"of the node the controller runs on when they are not set.")
+	flag.IntVar(&awsRetry.MaxRetries, "aws-max-retries", 3,
+		"How many times a failed AWS call is retried by the SDK before the reconcile gives up and requeues.")
+	flag.DurationVar(&awsRetry.BaseDelay, "aws-retry-base-delay", time.Second,
+		"The initial delay between AWS call retries and before requeueing after a retryable AWS error, doubled on each failure.")
+	flag.DurationVar(&awsRetry.MaxDelay, "aws-retry-max-delay", 5*time.Minute,
+		"The maximum delay between AWS call retries and before requeueing after a retryable AWS error.")
+	flag.Float64Var(&awsRetry.Jitter, "aws-retry-jitter", 0.2,
+		"The fraction, between 0 and 1, by which requeue delays after retryable AWS errors are randomized.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 5*time.Minute,
+		"How long the AWS calls of a single reconcile may take before they are cancelled. 0 means no limit.")
+	flag.DurationVar(&auditInterval, "audit-interval", 5*time.Minute,
+		"How often Ready fargate-profiles are compared with AWS to detect changes made outside of the controller. 0 disables the audit.")
+	flag.StringVar(&controllerID, "controller-id", "default",
+		"Identifies this controller instance in the ownership tags of the fargate-profiles it creates. "+
+			"Must be unique per controller installation managing the same eks clusters.")
+	flag.StringVar(&profileNameTemplate, "profile-name-template", controllers.DefaultProfileNameTemplate,
+		"Go template for the names of fargate-profiles without spec.profileName, e.g. {{.Namespace}}-{{.Name}}. "+
+			"Can use .Name, .Namespace and .ClusterName. Names are truncated and hashed to fit the EKS limits.")
+	flag.StringVar(&defaultDeletionPolicy, "default-deletion-policy", string(agillappsv1alpha1.Delete),
+		"What happens to the fargate-profile on AWS side when a FargateProfile without spec.deletionPolicy is deleted, Delete or Retain.")
+	flag.StringVar(&defaultTags, "default-tags", "",
+		"Tags merged into the tags of every fargate-profile, as key=value pairs separated by commas, e.g. cost-center=1234,owner=platform.")
+	flag.StringVar(&defaultTagsPrecedence, "default-tags-precedence", string(agillappsv1alpha1.SpecTagsFirst),
+		"Which side wins when spec.tags and --default-tags set the same key, Spec or Default.")
+	flag.StringVar(&protectedTagKeys, "protected-tag-keys", "",
+		"Tag keys separated by commas that spec.tags may not override. Their --default-tags value always wins.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -69,14 +117,85 @@ func main() {
 		os.Exit(1)
 	}
 
+	if policy := agillappsv1alpha1.DeletionPolicy(defaultDeletionPolicy); policy != agillappsv1alpha1.Delete && policy != agillappsv1alpha1.Retain {
+		setupLog.Error(fmt.Errorf("must be %v or %v", agillappsv1alpha1.Delete, agillappsv1alpha1.Retain), "invalid --default-deletion-policy")
+		os.Exit(1)
+	}
+
+	if precedence := agillappsv1alpha1.TagPrecedence(defaultTagsPrecedence); precedence != agillappsv1alpha1.SpecTagsFirst && precedence != agillappsv1alpha1.DefaultTagsFirst {
+		setupLog.Error(fmt.Errorf("must be %v or %v", agillappsv1alpha1.SpecTagsFirst, agillappsv1alpha1.DefaultTagsFirst), "invalid --default-tags-precedence")
+		os.Exit(1)
+	}
+
+	defaultTagsMap, err := controllers.ParseDefaultTags(defaultTags)
+	if err != nil {
+		setupLog.Error(err, "invalid --default-tags")
+		os.Exit(1)
+	}
+	var tagDefaults *agillappsv1alpha1.DefaultTags
+	if len(defaultTagsMap) > 0 || protectedTagKeys != "" {
+		tagDefaults = &agillappsv1alpha1.DefaultTags{
+			Tags:       defaultTagsMap,
+			Precedence: agillappsv1alpha1.TagPrecedence(defaultTagsPrecedence),
+		}
+		for _, key := range strings.Split(protectedTagKeys, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				tagDefaults.ProtectedKeys = append(tagDefaults.ProtectedKeys, key)
+			}
+		}
+	}
+
+	profileNameTmpl, err := controllers.ParseProfileNameTemplate(profileNameTemplate)
+	if err != nil {
+		setupLog.Error(err, "invalid --profile-name-template")
+		os.Exit(1)
+	}
+
+	if detectDefaults && (defaultRegion == "" || defaultClusterName == "") {
+		detectCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		detectedRegion, detectedClusterName, err := controllers.DetectDefaults(detectCtx, awsRetry)
+		cancel()
+		if err != nil {
+			setupLog.Error(err, "unable to detect default region and cluster name")
+		}
+		if defaultRegion == "" {
+			defaultRegion = detectedRegion
+		}
+		if defaultClusterName == "" {
+			defaultClusterName = detectedClusterName
+		}
+	}
+	setupLog.Info("spec defaults", "region", defaultRegion, "clusterName", defaultClusterName)
+
 	if err = (&controllers.FargateProfileReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("FargateProfile"),
-		Scheme: mgr.GetScheme(),
+		Client:     mgr.GetClient(),
+		Log:        ctrl.Log.WithName("controllers").WithName("FargateProfile"),
+		Scheme:     mgr.GetScheme(),
+		Recorder:   mgr.GetEventRecorderFor("eks-fargate-controller"),
+		AwsClients: controllers.NewCachedAwsClientFactory(awsRetry),
+		Backoff:    controllers.NewRequeueBackoff(awsRetry),
+
+		ClusterLocks:          controllers.NewClusterLocks(),
+		ControllerID:          controllerID,
+		ProfileNameTemplate:   profileNameTmpl,
+		DefaultDeletionPolicy: agillappsv1alpha1.DeletionPolicy(defaultDeletionPolicy),
+		DefaultTags:           tagDefaults,
+		ReconcileTimeout:      reconcileTimeout,
+		AuditInterval:         auditInterval,
+		DefaultRegion:         defaultRegion,
+		DefaultClusterName:    defaultClusterName,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "FargateProfile")
 		os.Exit(1)
 	}
+	// webhooks need serving certs ( see config/certmanager ), so they are opt-in
+	if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+		agillappsv1alpha1.SetWebhookDefaults(defaultRegion, defaultClusterName)
+		if err = (&agillappsv1alpha1.FargateProfile{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "FargateProfile")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")